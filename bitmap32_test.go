@@ -0,0 +1,386 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The structural index on uint32 words, which was used before uint64 words.
+// It is kept as the reference of the results and the performance.
+
+func removeRightmost1Uint32(x uint32) uint32 {
+	return x & (x - 1)
+}
+
+func extractRightmost1Uint32(x uint32) uint32 {
+	return x & -x
+}
+
+func smearRightmost1Uint32(x uint32) uint32 {
+	return x ^ (x - 1)
+}
+
+func popcntUint32(x uint32) int {
+	return bits.OnesCount32(x)
+}
+
+type structualCharacterBitmapsUint32 struct {
+	backslashes []uint32
+	quotes      []uint32
+	colons      []uint32
+	lBraces     []uint32
+	rBraces     []uint32
+	commas      []uint32
+	lBrackets   []uint32
+	rBrackets   []uint32
+}
+
+func buildStructualCharacterBitmapsUint32(json []byte) *structualCharacterBitmapsUint32 {
+	indices := map[byte]int{'\\': 0, '"': 1, ':': 2, '{': 3, '}': 4, ',': 5, '[': 6, ']': 7}
+	jsonLen := len(json)
+	bitmapLen := (jsonLen-1)/32 + 1
+	bitmaps := [][]uint32{
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+		make([]uint32, bitmapLen),
+	}
+
+	for i := 0; i < bitmapLen; i++ {
+		sublen := jsonLen - i*32
+		if sublen > 32 {
+			sublen = 32
+		}
+		for _, x := range json[i*32 : i*32+sublen] {
+			for _, bitmap := range bitmaps {
+				bitmap[i] >>= 1
+			}
+
+			j, ok := indices[x]
+			if ok {
+				bitmaps[j][i] |= 1 << 31
+			}
+		}
+
+		for _, bitmap := range bitmaps {
+			bitmap[i] >>= uint(32 - sublen)
+		}
+	}
+
+	return &structualCharacterBitmapsUint32{
+		backslashes: bitmaps[indices['\\']],
+		quotes:      bitmaps[indices['"']],
+		colons:      bitmaps[indices[':']],
+		lBraces:     bitmaps[indices['{']],
+		rBraces:     bitmaps[indices['}']],
+		commas:      bitmaps[indices[',']],
+		lBrackets:   bitmaps[indices['[']],
+		rBrackets:   bitmaps[indices[']']],
+	}
+}
+
+func buildStructualQuoteBitmapUint32(bitmaps *structualCharacterBitmapsUint32) ([]uint32, error) {
+	backslashes := bitmaps.backslashes
+	quotes := bitmaps.quotes
+	bitmapLen := len(backslashes)
+	backsalashedQuotes := make([]uint32, bitmapLen)
+	for i := 0; i < bitmapLen-1; i++ {
+		backsalashedQuotes[i] = ((quotes[i] >> 1) | (quotes[i+1] << 31)) & backslashes[i]
+	}
+	backsalashedQuotes[bitmapLen-1] = (quotes[bitmapLen-1] >> 1) & backslashes[bitmapLen-1]
+
+	unstructualQuotes := make([]uint32, bitmapLen)
+	for i := 0; i < bitmapLen; i++ {
+		var unstructualQuote uint32
+		backsalashedQuote := backsalashedQuotes[i]
+		for backsalashedQuote != 0 {
+			mask := smearRightmost1Uint32(backsalashedQuote)
+			numberOfOnes := popcntUint32(mask)
+			backslashOnLeft := (backslashes[i] & mask) << uint(32-numberOfOnes)
+			numberOfLeadingOnes := bits.LeadingZeros32(^backslashOnLeft)
+			if numberOfLeadingOnes == numberOfOnes {
+				for j := i - 1; j >= 0; j-- {
+					numberOfLeadingOneInWord := bits.LeadingZeros32(^backslashes[j])
+					if numberOfLeadingOneInWord < 32 {
+						numberOfLeadingOnes += numberOfLeadingOneInWord
+						break
+					}
+				}
+			} else if numberOfLeadingOnes > numberOfOnes {
+				return nil, fmt.Errorf("illegal state of backslash bitmap at word %d", i)
+			}
+			if numberOfLeadingOnes&1 == 1 {
+				unstructualQuote = unstructualQuote | extractRightmost1Uint32(backsalashedQuote)
+			}
+			backsalashedQuote = removeRightmost1Uint32(backsalashedQuote)
+		}
+		unstructualQuotes[i] = ^unstructualQuote
+	}
+
+	structualQuotes := make([]uint32, bitmapLen)
+	structualQuotes[0] = quotes[0] & (unstructualQuotes[0]<<1 | 1)
+	for i := 1; i < bitmapLen; i++ {
+		structualQuotes[i] = quotes[i] & ((unstructualQuotes[i] << 1) | (unstructualQuotes[i-1] >> 31))
+	}
+	return structualQuotes, nil
+}
+
+func buildStringMaskBitmapUint32(quoteBitmaps []uint32) []uint32 {
+	// return []uint32{}
+	bitmapLen := len(quoteBitmaps)
+	n := 0
+	stringBitmap := make([]uint32, bitmapLen)
+	for i := 0; i < bitmapLen; i++ {
+		quoteMask := quoteBitmaps[i]
+		var stringMask uint32
+		for quoteMask != 0 {
+			mask := smearRightmost1Uint32(quoteMask)
+			stringMask ^= mask
+			quoteMask = removeRightmost1Uint32(quoteMask)
+			n++
+		}
+		if n%2 == 1 {
+			stringMask = ^stringMask
+		}
+		stringBitmap[i] = stringMask
+	}
+	return stringBitmap
+}
+
+type maskStackUint32 struct {
+	body []struct {
+		index int
+		mask  uint32
+	}
+	sp int
+}
+
+func newMaskStackUint32() *maskStackUint32 {
+	return &maskStackUint32{
+		body: make([]struct {
+			index int
+			mask  uint32
+		}, stackInitialSize),
+		sp: 0,
+	}
+}
+
+func (s *maskStackUint32) push(index int, mask uint32) error {
+	if s.sp == len(s.body) {
+		s.body = append(s.body, struct {
+			index int
+			mask  uint32
+		}{})
+	}
+
+	s.body[s.sp].index = index
+	s.body[s.sp].mask = mask
+	s.sp++
+	return nil
+}
+
+func (s *maskStackUint32) pop() (int, uint32, error) {
+	if s.sp == 0 {
+		return 0, 0, errors.New("attempt pop from empty stack")
+	}
+
+	s.sp--
+	return s.body[s.sp].index, s.body[s.sp].mask, nil
+}
+
+func buildLeveledBitmapsUint32(bitmaps *structualCharacterBitmapsUint32, stringMaskBitmap []uint32, level int) ([][]uint32, [][]uint32, error) {
+	bitmapLen := len(stringMaskBitmap)
+	colons := bitmaps.colons
+	commas := bitmaps.commas
+	lBraces := bitmaps.lBraces
+	rBraces := bitmaps.rBraces
+	lBrackets := bitmaps.lBrackets
+	rBrackets := bitmaps.rBrackets
+
+	// make structual characters to be structual
+	for i := 0; i < bitmapLen; i++ {
+		stringMask := ^stringMaskBitmap[i]
+		colons[i] &= stringMask
+		commas[i] &= stringMask
+		lBraces[i] &= stringMask
+		rBraces[i] &= stringMask
+		lBrackets[i] &= stringMask
+		rBrackets[i] &= stringMask
+	}
+
+	colonBitmaps := make([][]uint32, level)
+	commaBitmaps := make([][]uint32, level)
+	for i := 0; i < level; i++ {
+		colonBitmaps[i] = make([]uint32, bitmapLen)
+		copy(colonBitmaps[i], colons)
+		commaBitmaps[i] = make([]uint32, bitmapLen)
+		copy(commaBitmaps[i], commas)
+	}
+	stack := newMaskStackUint32()
+
+	for i := 0; i < bitmapLen; i++ {
+		mLeft := lBraces[i] | lBrackets[i]
+		mRight := rBraces[i] | rBrackets[i]
+		for {
+			mLeftBit := extractRightmost1Uint32(mLeft)
+			mRightBit := extractRightmost1Uint32(mRight)
+			for mLeftBit != 0 && (mRightBit == 0 || mLeftBit < mRightBit) {
+				stack.push(i, mLeftBit)
+				mLeft = removeRightmost1Uint32(mLeft)
+				mLeftBit = extractRightmost1Uint32(mLeft)
+			}
+			if mRightBit != 0 {
+				var j int
+				var err error
+				isBrace := rBraces[i]&mRightBit != 0
+				j, mLeftBit, err = stack.pop()
+				if err != nil {
+					if isBrace {
+						return nil, nil, newSyntaxError(i*32+popcntUint32(mRightBit-1), ErrUnexpectedClosing, "unexpected right curry blace")
+					}
+					return nil, nil, newSyntaxError(i*32+popcntUint32(mRightBit-1), ErrUnexpectedClosing, "unexpected right bracket")
+				}
+				if isBrace != (lBraces[j]&mLeftBit != 0) {
+					return nil, nil, newSyntaxError(i*32+popcntUint32(mRightBit-1), ErrUnexpectedClosing, "mismatched closing character")
+				}
+				if stack.sp > 0 && stack.sp <= level {
+					for _, leveled := range [][]uint32{colonBitmaps[stack.sp-1], commaBitmaps[stack.sp-1]} {
+						if i == j {
+							leveled[i] &= ^(mRightBit - mLeftBit)
+						} else {
+							leveled[j] &= mLeftBit - 1
+							leveled[i] &= ^(mRightBit - 1)
+							for k := j + 1; k < i; k++ {
+								leveled[k] = 0
+							}
+						}
+					}
+				}
+			} else {
+				break
+			}
+			mRight = removeRightmost1Uint32(mRight)
+		}
+	}
+
+	if stack.sp > 0 {
+		j, mLeftBit, _ := stack.pop()
+		return nil, nil, newSyntaxError(j*32+popcntUint32(mLeftBit-1), ErrUnclosed, "unclosed left brace or bracket")
+	}
+
+	return colonBitmaps, commaBitmaps, nil
+}
+
+func buildStructualIndexUint32(json []byte, level int) ([]uint32, [][]uint32, [][]uint32, error) {
+	charactersBitmaps := buildStructualCharacterBitmapsUint32(json)
+	quoteBitmap, err := buildStructualQuoteBitmapUint32(charactersBitmaps)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stringMaskBitmap := buildStringMaskBitmapUint32(quoteBitmap)
+	leveledColonBitmaps, leveledCommaBitmaps, err := buildLeveledBitmapsUint32(charactersBitmaps, stringMaskBitmap, level)
+	return stringMaskBitmap, leveledColonBitmaps, leveledCommaBitmaps, err
+}
+
+func bitmapPositions(n int, bitAt func(i int) bool) []int {
+	positions := make([]int, 0)
+	for i := 0; i < n; i++ {
+		if bitAt(i) {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+func uint32Positions(bitmap []uint32, n int) []int {
+	return bitmapPositions(n, func(i int) bool { return bitmap[i/32]&(1<<uint(i%32)) != 0 })
+}
+
+func uint64Positions(bitmap []uint64, n int) []int {
+	return bitmapPositions(n, func(i int) bool { return bitmap[i/64]&(1<<uint(i%64)) != 0 })
+}
+
+func TestStructualIndexMatchesUint32(t *testing.T) {
+	records := []string{
+		`{"a\\\\\"b":1,"c":[{"d":"\\"},2,"]"],"e":{"f":"x:y,z"}}`,
+		`{"a":"` + strings.Repeat(`\\`, 70) + `","b":[[{"c":"\""}]]}`,
+	}
+
+	for _, record := range records {
+		for pad := 0; pad < 140; pad += 7 {
+			json := []byte(strings.Repeat(" ", pad) + record)
+			t.Run(fmt.Sprintf("pad=%d: %s", pad, record), func(t *testing.T) {
+				n := len(json)
+				expectedMask, expectedColons, expectedCommas, err := buildStructualIndexUint32(json, 3)
+				if !assert.NoError(t, err) {
+					return
+				}
+				actual, err := buildStructualIndex(json, 3, nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				assert.Equal(t, uint32Positions(expectedMask, n), uint64Positions(actual.stringMaskBitmap, n))
+				for l := 0; l < 3; l++ {
+					assert.Equal(t, uint32Positions(expectedColons[l], n), uint64Positions(actual.leveledColonBitmaps[l], n))
+					assert.Equal(t, uint32Positions(expectedCommas[l], n), uint64Positions(actual.leveledCommaBitmaps[l], n))
+				}
+			})
+		}
+	}
+}
+
+func benchmarkRecord() []byte {
+	var b strings.Builder
+	b.WriteString(`{"id":1,"items":[`)
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"name":"item \"%d\"","price":%d.5,"tags":["a","b\\c"],"attrs":{"x":true,"y":null}}`, i, i)
+	}
+	b.WriteString(`],"payload":"` + strings.Repeat("lorem ipsum ", 200) + `"}`)
+	return []byte(b.String())
+}
+
+func BenchmarkBuildStructualIndex(b *testing.B) {
+	json := benchmarkRecord()
+
+	b.Run("uint32", func(b *testing.B) {
+		b.SetBytes(int64(len(json)))
+		for i := 0; i < b.N; i++ {
+			if _, _, _, err := buildStructualIndexUint32(json, 3); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uint64", func(b *testing.B) {
+		b.SetBytes(int64(len(json)))
+		for i := 0; i < b.N; i++ {
+			if _, err := buildStructualIndex(json, 3, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uint64-portable", func(b *testing.B) {
+		withoutSIMD(func() {
+			b.SetBytes(int64(len(json)))
+			for i := 0; i < b.N; i++ {
+				if _, err := buildStructualIndex(json, 3, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,400 @@
+package mison
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// UnmarshalTypeError represents that a JSON value cannot be stored into the struct field
+type UnmarshalTypeError struct {
+	// Query is the queried field of the value
+	Query string
+	// Field is the name of the struct field
+	Field string
+	// JSONType is the type of the JSON value
+	JSONType JSONType
+	// RawValue is the raw value in JSON
+	RawValue string
+	// Type is the type of the struct field
+	Type reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("cannot unmarshal JSON %s %s of %q into Go struct field %s of type %s", e.JSONType, e.RawValue, e.Query, e.Field, e.Type)
+}
+
+type decoderField struct {
+	query    string
+	name     string
+	index    []int
+	appended bool
+}
+
+// Decoder decodes queried fields of records into structs
+type Decoder struct {
+	p      *Parser
+	typ    reflect.Type
+	fields []decoderField
+}
+
+/*
+NewDecoderFor creates a new Decoder for the struct type pointed by v.
+
+The queried fields are derived from the tags of struct fields.
+The `mison` tag is a queried field (e.g. `mison:"user.name"`), and the `json` tag is a field name.
+Fields of struct type are decoded from nested objects, and fields of slice type are appended
+the elements of queried arrays (e.g. `mison:"tags[]"`).
+Fields of json.RawMessage are stored the raw values, including whole objects and arrays.
+Fields without tags or with empty names in the `json` tag use the names of struct fields,
+and fields of untagged embedded structs and pointers to structs are promoted as encoding/json does.
+Nil embedded pointers are allocated when their fields are found.
+Unexported fields and fields with tag "-" are ignored.
+Fields of other types than strings, bools, numbers, empty interfaces, json.RawMessage,
+pointers to them and slices of them (and structs of such fields) are rejected.
+*/
+func NewDecoderFor(v interface{}, options ...ParserOption) (*Decoder, error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pointer to struct is expected, but got %v", typ)
+	}
+	typ = typ.Elem()
+
+	fields, err := collectDecoderFields(typ, "", typ.Name(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%v has no queried fields", typ)
+	}
+
+	queriedFields := make([]string, len(fields))
+	for i, f := range fields {
+		queriedFields[i] = f.query
+	}
+	p, err := NewParser(queriedFields, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoder{p: p, typ: typ, fields: fields}, nil
+}
+
+/*
+collectDecoderFields collects the queried fields of the struct type.
+parents are the struct types enclosing typ, which stop the recursion of embedded pointers.
+*/
+func collectDecoderFields(typ reflect.Type, prefix, name string, index []int, parents []reflect.Type) ([]decoderField, error) {
+	parents = append(parents, typ)
+	fields := make([]decoderField, 0)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		ft := sf.Type
+		misonTag, jsonTag := sf.Tag.Get("mison"), sf.Tag.Get("json")
+		fieldIndex := append(append([]int{}, index...), i)
+		fieldName := name + "." + sf.Name
+
+		// fields of untagged embedded structs and pointers to structs are promoted as encoding/json does
+		embedded := ft
+		if embedded.Kind() == reflect.Ptr && sf.PkgPath == "" {
+			embedded = embedded.Elem()
+		}
+		if sf.Anonymous && embedded.Kind() == reflect.Struct && misonTag == "" && strings.SplitN(jsonTag, ",", 2)[0] == "" {
+			if containsType(parents, embedded) {
+				// the fields are shadowed by the same fields of the enclosing struct
+				continue
+			}
+			children, err := collectDecoderFields(embedded, prefix, fieldName, fieldIndex, parents)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, children...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		appended := ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8
+		query := misonTag
+		if query == "" {
+			if jsonTag == "-" {
+				continue
+			}
+			fieldKey := strings.SplitN(jsonTag, ",", 2)[0]
+			if fieldKey == "" {
+				fieldKey = sf.Name
+			}
+			query = escapeQueriedFieldName(fieldKey)
+			if appended {
+				query += "[]"
+			}
+		} else if query == "-" {
+			continue
+		}
+		query = prefix + query
+
+		if ft.Kind() == reflect.Struct {
+			children, err := collectDecoderFields(ft, query+".", fieldName, fieldIndex, parents)
+			if err != nil {
+				return nil, err
+			}
+			if len(children) == 0 {
+				return nil, fmt.Errorf("struct field %s of type %v has no queried fields", fieldName, ft)
+			}
+			fields = append(fields, children...)
+			continue
+		}
+
+		if appended != strings.Contains(query, "[]") {
+			return nil, fmt.Errorf("type of struct field %s does not match with queried field %q", fieldName, query)
+		}
+		valueType := ft
+		if appended {
+			valueType = ft.Elem()
+		}
+		if !isDecodableType(valueType) {
+			return nil, fmt.Errorf("unsupported type %v of struct field %s", ft, fieldName)
+		}
+		fields = append(fields, decoderField{query: query, name: fieldName, index: fieldIndex, appended: appended})
+	}
+	return fields, nil
+}
+
+func containsType(types []reflect.Type, typ reflect.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isDecodableType reports whether storeValue can store values into typ.
+*/
+func isDecodableType(typ reflect.Type) bool {
+	if typ == rawMessageType {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return isDecodableType(typ.Elem())
+	case reflect.Interface:
+		return typ.NumMethod() == 0
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func escapeQueriedFieldName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if c == '.' || c == '[' || c == ']' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Parser returns the Parser for the queried fields of the Decoder
+func (d *Decoder) Parser() *Parser {
+	return d.p
+}
+
+/*
+Decode parses the record and stores the queried fields into v.
+The ParserStates for records are pooled and reused.
+*/
+func (d *Decoder) Decode(json []byte, v interface{}) error {
+	ps := d.p.states.Get().(*ParserState)
+	defer d.p.states.Put(ps)
+	if err := ps.Reset(json); err != nil {
+		return err
+	}
+	return d.DecodeState(ps, v)
+}
+
+/*
+DecodeState stores the rest of key/values in the ParserState into v.
+Slice fields are truncated before decoding.
+*/
+func (d *Decoder) DecodeState(ps *ParserState, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != d.typ {
+		return fmt.Errorf("non-nil *%v is expected, but got %T", d.typ, v)
+	}
+	rv = rv.Elem()
+
+	for _, f := range d.fields {
+		if f.appended {
+			if fv, ok := fieldByIndex(rv, f.index, false); ok {
+				fv.SetLen(0)
+			}
+		}
+	}
+
+	for {
+		kv, err := ps.Next()
+		if err != nil {
+			return err
+		}
+		if kv.IsEndOfRecord() {
+			return nil
+		}
+
+		f := &d.fields[kv.FieldID]
+		fv, _ := fieldByIndex(rv, f.index, true)
+		if f.appended {
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			if err := storeValue(elem, kv); err != nil {
+				return f.typeError(err)
+			}
+			fv.Set(reflect.Append(fv, elem))
+		} else if err := storeValue(fv, kv); err != nil {
+			return f.typeError(err)
+		}
+	}
+}
+
+/*
+fieldByIndex returns the nested field of v by index.
+Nil embedded pointers on the path are allocated if alloc is true, otherwise fieldByIndex returns false.
+*/
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func (f *decoderField) typeError(err error) error {
+	var typeErr *UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		typeErr.Query = f.query
+		typeErr.Field = f.name
+		return typeErr
+	}
+	return fmt.Errorf("cannot unmarshal %q into Go struct field %s: %w", f.query, f.name, err)
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+/*
+storeValue stores the value of kv into v with type conversion.
+null is stored only into pointers and interfaces, and ignored for other types.
+Objects and arrays are stored only into json.RawMessage and interfaces.
+*/
+func storeValue(v reflect.Value, kv *KeyValue) error {
+	mismatch := func() error {
+		return &UnmarshalTypeError{JSONType: kv.Type, RawValue: kv.RawValue, Type: v.Type()}
+	}
+
+	if v.Type() == rawMessageType {
+		v.SetBytes(append(json.RawMessage(nil), kv.RawValue...))
+		return nil
+	}
+
+	if kv.Type == JSONNull {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return storeValue(v.Elem(), kv)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch()
+		}
+		v.Set(reflect.ValueOf(kv.Value))
+	case reflect.String:
+		if kv.Type != JSONString {
+			return mismatch()
+		}
+		v.SetString(kv.Value.(string))
+	case reflect.Bool:
+		if kv.Type != JSONBool {
+			return mismatch()
+		}
+		v.SetBool(kv.Value.(bool))
+	case reflect.Float32, reflect.Float64:
+		if kv.Type != JSONNumber {
+			return mismatch()
+		}
+		f, err := floatValue(kv)
+		if err != nil || v.OverflowFloat(f) {
+			return mismatch()
+		}
+		v.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if kv.Type != JSONNumber {
+			return mismatch()
+		}
+		n, err := strconv.ParseInt(kv.RawValue, 10, 64)
+		if err != nil {
+			f, err := floatValue(kv)
+			if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return mismatch()
+			}
+			n = int64(f)
+		}
+		if v.OverflowInt(n) {
+			return mismatch()
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if kv.Type != JSONNumber {
+			return mismatch()
+		}
+		n, err := strconv.ParseUint(kv.RawValue, 10, 64)
+		if err != nil {
+			f, err := floatValue(kv)
+			if err != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return mismatch()
+			}
+			n = uint64(f)
+		}
+		if v.OverflowUint(n) {
+			return mismatch()
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+/*
+floatValue returns the number of kv as float64 regardless of the number mode of the Parser.
+*/
+func floatValue(kv *KeyValue) (float64, error) {
+	if f, ok := kv.Value.(float64); ok {
+		return f, nil
+	}
+	return strconv.ParseFloat(kv.RawValue, 64)
+}
@@ -0,0 +1,280 @@
+package mison
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type decoderTestUser struct {
+	Name string `json:"name"`
+	Age  *int   `mison:"age"`
+}
+
+type decoderTestRecord struct {
+	ID      int64           `json:"id"`
+	Score   float64         `mison:"stats.score"`
+	Count   uint8           `mison:"stats.count"`
+	Active  bool            `json:"active,omitempty"`
+	User    decoderTestUser `mison:"user"`
+	Tags    []string        `json:"tags"`
+	Prices  []float32       `mison:"items[].price"`
+	Dotted  string          `json:"a.b"`
+	Any     interface{}     `mison:"any"`
+	Raw     json.RawMessage `mison:"raw"`
+	Ignored string          `json:"-"`
+	Untaged string
+	private string
+}
+
+func TestNewDecoderFor(t *testing.T) {
+	d, err := NewDecoderFor(&decoderTestRecord{})
+	if assert.NoError(t, err) {
+		queries := make([]string, len(d.fields))
+		for i, f := range d.fields {
+			queries[i] = f.query
+		}
+		assert.Equal(t, []string{"id", "stats.score", "stats.count", "active", "user.name", "user.age", "tags[]", "items[].price", `a\.b`, "any", "raw", "Untaged"}, queries)
+	}
+
+	errCases := []interface{}{
+		decoderTestRecord{},
+		nil,
+		&struct{ a int }{},
+		&struct {
+			A int `json:"-"`
+		}{},
+		&struct {
+			T time.Time `json:"t"`
+		}{},
+		&struct {
+			Users []decoderTestUser `json:"users"`
+		}{},
+		&struct {
+			User *decoderTestUser `json:"user"`
+		}{},
+		&struct {
+			M map[string]int `json:"m"`
+		}{},
+		&struct {
+			B []byte `json:"b"`
+		}{},
+		&struct {
+			E error `json:"e"`
+		}{},
+		&struct {
+			C []chan int `json:"c"`
+		}{},
+		&struct {
+			A []int `mison:"a"`
+		}{},
+		&struct {
+			A int `mison:"a[]"`
+		}{},
+		&struct {
+			A int `mison:"a"`
+			B int `json:"a"`
+		}{},
+	}
+
+	for i, v := range errCases {
+		t.Run(fmt.Sprintf("errCase%d: %T", i, v), func(t *testing.T) {
+			_, err := NewDecoderFor(v)
+			assert.Error(t, err)
+		})
+	}
+}
+
+type decoderTestEmbedded struct {
+	Kind string `json:"kind"`
+}
+
+func TestDecoderFieldNames(t *testing.T) {
+	type record struct {
+		decoderTestEmbedded
+		Name   string   `json:",omitempty"`
+		Tags   []string `json:",omitempty"`
+		Dotted int      `json:"a.b,omitempty"`
+		Count  *int
+		Raw    json.RawMessage
+		Named  decoderTestEmbedded `json:"named"`
+		Nested struct {
+			Value float64
+		}
+	}
+
+	d, err := NewDecoderFor(&record{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	queries := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		queries[i] = f.query
+	}
+	assert.Equal(t, []string{"kind", "Name", "Tags[]", `a\.b`, "Count", "Raw", "named.kind", "Nested.Value"}, queries)
+
+	var actual record
+	data := `{"kind":"k","Name":"n","Tags":["x"],"a.b":1,"Count":2,"Raw":[],"named":{"kind":"m"},"Nested":{"Value":0.5}}`
+	if assert.NoError(t, d.Decode([]byte(data), &actual)) {
+		count := 2
+		expected := record{
+			decoderTestEmbedded: decoderTestEmbedded{Kind: "k"}, Name: "n", Tags: []string{"x"}, Dotted: 1, Count: &count,
+			Raw: json.RawMessage(`[]`), Named: decoderTestEmbedded{Kind: "m"},
+		}
+		expected.Nested.Value = 0.5
+		assert.Equal(t, expected, actual)
+	}
+}
+
+// EmbeddedStats is exported to be promoted through an embedded pointer
+type EmbeddedStats struct {
+	Score  float64  `json:"score"`
+	Labels []string `json:"labels"`
+}
+
+// EmbeddedNode embeds the pointer to itself
+type EmbeddedNode struct {
+	ID int `json:"id"`
+	*EmbeddedNode
+}
+
+func TestDecoderEmbeddedPointer(t *testing.T) {
+	type record struct {
+		ID int `json:"id"`
+		*EmbeddedStats
+		*decoderTestEmbedded
+	}
+
+	d, err := NewDecoderFor(&record{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	queries := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		queries[i] = f.query
+	}
+	assert.Equal(t, []string{"id", "score", "labels[]"}, queries)
+
+	cases := []struct {
+		json     string
+		actual   record
+		expected record
+	}{
+		{
+			json:     `{"id":1,"score":0.5,"labels":["a"],"kind":"k"}`,
+			expected: record{ID: 1, EmbeddedStats: &EmbeddedStats{Score: 0.5, Labels: []string{"a"}}},
+		},
+		{
+			json:     `{"id":1}`,
+			expected: record{ID: 1},
+		},
+		{
+			json:     `{"id":2}`,
+			actual:   record{EmbeddedStats: &EmbeddedStats{Score: 1, Labels: []string{"old"}}},
+			expected: record{ID: 2, EmbeddedStats: &EmbeddedStats{Score: 1, Labels: []string{}}},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			if assert.NoError(t, d.Decode([]byte(tt.json), &tt.actual)) {
+				assert.Equal(t, tt.expected, tt.actual)
+			}
+		})
+	}
+
+	d, err = NewDecoderFor(&EmbeddedNode{})
+	if assert.NoError(t, err) {
+		var node EmbeddedNode
+		if assert.NoError(t, d.Decode([]byte(`{"id":1}`), &node)) {
+			assert.Equal(t, EmbeddedNode{ID: 1}, node)
+		}
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	age := 20
+	cases := []struct {
+		json     string
+		expected decoderTestRecord
+	}{
+		{
+			json: `{"id":9007199254740993,"stats":{"score":1.5,"count":3},"active":true,"user":{"name":"autopp","age":20},` +
+				`"tags":["a","b"],"items":[{"price":1},{"price":2.5}],"a.b":"dot","any":"x","raw":{"x":[1, 2]},"Untaged":"u"}`,
+			expected: decoderTestRecord{
+				ID: 9007199254740993, Score: 1.5, Count: 3, Active: true, User: decoderTestUser{Name: "autopp", Age: &age},
+				Tags: []string{"a", "b"}, Prices: []float32{1, 2.5}, Dotted: "dot", Any: "x", Raw: json.RawMessage(`{"x":[1, 2]}`),
+				Untaged: "u",
+			},
+		},
+		{
+			json:     `{"id":1e2,"user":{"age":null},"tags":[],"any":null,"raw":null}`,
+			expected: decoderTestRecord{ID: 100, Tags: []string{}, Prices: []float32{}, Raw: json.RawMessage(`null`)},
+		},
+		{
+			json:     `{"any":[{"a":1}],"raw":"\n"}`,
+			expected: decoderTestRecord{Prices: []float32{}, Any: json.RawMessage(`[{"a":1}]`), Raw: json.RawMessage(`"\n"`)},
+		},
+	}
+
+	d, err := NewDecoderFor(&decoderTestRecord{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			actual := decoderTestRecord{Tags: []string{"old"}, Prices: []float32{}}
+			if assert.NoError(t, d.Decode([]byte(tt.json), &actual)) {
+				if tt.expected.Tags == nil {
+					tt.expected.Tags = []string{}
+				}
+				assert.Equal(t, tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecoderDecodeError(t *testing.T) {
+	cases := []struct {
+		json  string
+		query string
+		field string
+		typ   reflect.Type
+	}{
+		{json: `{"id":"1"}`, query: "id", field: "decoderTestRecord.ID", typ: reflect.TypeOf(int64(0))},
+		{json: `{"id":1.5}`, query: "id", field: "decoderTestRecord.ID", typ: reflect.TypeOf(int64(0))},
+		{json: `{"id":{"a":1}}`, query: "id", field: "decoderTestRecord.ID", typ: reflect.TypeOf(int64(0))},
+		{json: `{"a.b":[]}`, query: `a\.b`, field: "decoderTestRecord.Dotted", typ: reflect.TypeOf("")},
+		{json: `{"stats":{"count":256}}`, query: "stats.count", field: "decoderTestRecord.Count", typ: reflect.TypeOf(uint8(0))},
+		{json: `{"stats":{"count":-1}}`, query: "stats.count", field: "decoderTestRecord.Count", typ: reflect.TypeOf(uint8(0))},
+		{json: `{"user":{"age":true}}`, query: "user.age", field: "decoderTestRecord.User.Age", typ: reflect.TypeOf(0)},
+		{json: `{"tags":[1]}`, query: "tags[]", field: "decoderTestRecord.Tags", typ: reflect.TypeOf("")},
+		{json: `{"items":[{"price":1e40}]}`, query: "items[].price", field: "decoderTestRecord.Prices", typ: reflect.TypeOf(float32(0))},
+	}
+
+	d, err := NewDecoderFor(&decoderTestRecord{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			err := d.Decode([]byte(tt.json), &decoderTestRecord{})
+			var typeErr *UnmarshalTypeError
+			if assert.True(t, errors.As(err, &typeErr), "%v", err) {
+				assert.Equal(t, tt.query, typeErr.Query)
+				assert.Equal(t, tt.field, typeErr.Field)
+				assert.Equal(t, tt.typ, typeErr.Type)
+			}
+		})
+	}
+
+	assert.Error(t, d.Decode([]byte(`{"id":1}`), decoderTestRecord{}))
+	assert.Error(t, d.Decode([]byte(`{"id":1}`), &decoderTestUser{}))
+}
@@ -0,0 +1,124 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnexpectedClosing represents that a right brace or bracket does not match with the left one
+	ErrUnexpectedClosing = errors.New("unexpected closing character")
+	// ErrUnclosed represents that a left brace or bracket is not closed
+	ErrUnclosed = errors.New("unclosed object or array")
+	// ErrFieldNameNotFound represents that the field name for a colon is not found
+	ErrFieldNameNotFound = errors.New("field name is not found")
+	// ErrInvalidString represents that a string has invalid escape sequences or characters
+	ErrInvalidString = errors.New("invalid string")
+	// ErrValueNotFound represents that a value is missing
+	ErrValueNotFound = errors.New("value is not found")
+	// ErrInvalidValue represents that a value is not valid JSON value
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrNumberOutOfRange represents that a number cannot be represented in Go
+	ErrNumberOutOfRange = errors.New("number is out of range")
+	// ErrAlreadyFinished represents that ParserState.Next is called after the end of record
+	ErrAlreadyFinished = errors.New("already finished")
+	// ErrTypeMismatch represents that a value is accessed as another type
+	ErrTypeMismatch = errors.New("type mismatch")
+)
+
+const syntaxErrorContextSize = 16
+
+/*
+SyntaxError represents an error in the JSON record.
+Use errors.Is with the sentinel errors (e.g. ErrInvalidValue) to check the kind of the error.
+*/
+type SyntaxError struct {
+	// Offset is the byte offset in the record
+	Offset int
+	// Line is the line number in the record (starts from 1)
+	Line int
+	// Column is the byte column in the line (starts from 1)
+	Column int
+	// Context is an excerpt of the record around Offset
+	Context string
+	// Err is the sentinel error of the kind of the error
+	Err error
+	msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d (offset %d) near %q", e.msg, e.Line, e.Column, e.Offset, e.Context)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+/*
+newSyntaxError creates a new SyntaxError at offset.
+The location is not filled until locate is called.
+*/
+func newSyntaxError(offset int, err error, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Offset: offset, Err: err, msg: fmt.Sprintf(format, args...)}
+}
+
+/*
+locate fills the line, the column and the context of e from json.
+*/
+func (e *SyntaxError) locate(json []byte) *SyntaxError {
+	return e.locateAt(json, 0, 1, 0)
+}
+
+/*
+locateAt fills the line, the column and the context of e from json, which is a part of the record starting at base.
+line is the line number at base, and lineStart is the offset of the start of the line.
+The location is left unknown (zero) when Offset is before base.
+*/
+func (e *SyntaxError) locateAt(json []byte, base, line, lineStart int) *SyntaxError {
+	offset := e.Offset - base
+	if offset < 0 {
+		return e
+	}
+	if offset > len(json) {
+		offset = len(json)
+	}
+
+	e.Line = line
+	for i := 0; i < offset; i++ {
+		if json[i] == '\n' {
+			e.Line++
+			lineStart = base + i + 1
+		}
+	}
+	e.Column = base + offset - lineStart + 1
+
+	start := offset - syntaxErrorContextSize
+	if start < 0 {
+		start = 0
+	}
+	end := offset + syntaxErrorContextSize
+	if end > len(json) {
+		end = len(json)
+	}
+	e.Context = string(json[start:end])
+	return e
+}
+
+/*
+syntaxErrorAt creates a new SyntaxError at offset of json.
+*/
+func syntaxErrorAt(json []byte, offset int, err error, format string, args ...interface{}) *SyntaxError {
+	return newSyntaxError(offset, err, format, args...).locate(json)
+}
+
+/*
+stringError represents an error in the body of string at pos.
+*/
+type stringError struct {
+	pos int
+	msg string
+}
+
+func (e *stringError) Error() string {
+	return fmt.Sprintf("%s at %d", e.msg, e.pos)
+}
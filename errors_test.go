@@ -0,0 +1,80 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntaxErrorLocate(t *testing.T) {
+	json := []byte("{\n  \"a\": 1,\n  \"b\": x\n}")
+	e := newSyntaxError(19, ErrInvalidValue, "invalid value").locate(json)
+	assert.Equal(t, 3, e.Line)
+	assert.Equal(t, 8, e.Column)
+	assert.Equal(t, " \"a\": 1,\n  \"b\": x\n}", e.Context)
+	assert.Equal(t, `invalid value at line 3, column 8 (offset 19) near " \"a\": 1,\n  \"b\": x\n}"`, e.Error())
+	assert.True(t, errors.Is(e, ErrInvalidValue))
+}
+
+func TestParserStateErrors(t *testing.T) {
+	cases := []struct {
+		json          string
+		queriedFields []string
+		sentinel      error
+		offset        int
+		line          int
+		column        int
+	}{
+		{json: `{"a":1}}`, queriedFields: []string{"a"}, sentinel: ErrUnexpectedClosing, offset: 7, line: 1, column: 8},
+		{json: "{\"a\":[1,\n2}", queriedFields: []string{"a"}, sentinel: ErrUnexpectedClosing, offset: 10, line: 2, column: 2},
+		{json: `{"a":{"b":1}`, queriedFields: []string{"a"}, sentinel: ErrUnclosed, offset: 0, line: 1, column: 1},
+		{json: `{"a":}`, queriedFields: []string{"a"}, sentinel: ErrInvalidValue, offset: 5, line: 1, column: 6},
+		{json: `{"a":`, queriedFields: []string{"a"}, sentinel: ErrUnclosed, offset: 0, line: 1, column: 1},
+		{json: "{\n\"a\":tru}", queriedFields: []string{"a"}, sentinel: ErrInvalidValue, offset: 6, line: 2, column: 5},
+		{json: `{"a":1 2}`, queriedFields: []string{"a"}, sentinel: ErrInvalidValue, offset: 7, line: 1, column: 8},
+		{json: `{"a":"\x"}`, queriedFields: []string{"a"}, sentinel: ErrInvalidString, offset: 6, line: 1, column: 7},
+		{json: `{"a":1e999}`, queriedFields: []string{"a"}, sentinel: ErrNumberOutOfRange, offset: 5, line: 1, column: 6},
+		{json: `{"\q":1}`, queriedFields: []string{"a"}, sentinel: ErrInvalidString, offset: 2, line: 1, column: 3},
+		{json: `{1:1}`, queriedFields: []string{"a"}, sentinel: ErrFieldNameNotFound, offset: 2, line: 1, column: 3},
+		{json: `{"a":[1,,2]}`, queriedFields: []string{"a[]"}, sentinel: ErrValueNotFound, offset: 8, line: 1, column: 9},
+		{json: `{"a":{"b":1} 2}`, queriedFields: []string{"a"}, sentinel: ErrInvalidValue, offset: 13, line: 1, column: 14},
+		{json: `{"a":[[1] 2]}`, queriedFields: []string{"a[]"}, sentinel: ErrInvalidValue, offset: 10, line: 1, column: 11},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			p, err := NewParser(tt.queriedFields)
+			if !assert.NoError(t, err) {
+				return
+			}
+			ps, err := p.StartParse([]byte(tt.json))
+			for err == nil {
+				var kv *KeyValue
+				kv, err = ps.Next()
+				if err == nil && kv.IsEndOfRecord() {
+					break
+				}
+			}
+
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr), "%v", err) {
+				assert.True(t, errors.Is(err, tt.sentinel), "%v", err)
+				assert.Equal(t, tt.offset, syntaxErr.Offset)
+				assert.Equal(t, tt.line, syntaxErr.Line)
+				assert.Equal(t, tt.column, syntaxErr.Column)
+			}
+		})
+	}
+
+	t.Run("already finished", func(t *testing.T) {
+		p, _ := NewParser([]string{"a"})
+		ps, _ := p.StartParse([]byte(`{}`))
+		kv, err := ps.Next()
+		if assert.NoError(t, err) && assert.True(t, kv.IsEndOfRecord()) {
+			_, err = ps.Next()
+			assert.True(t, errors.Is(err, ErrAlreadyFinished))
+		}
+	})
+}
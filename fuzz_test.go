@@ -0,0 +1,304 @@
+//go:build go1.18
+// +build go1.18
+
+package mison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var fuzzQueriedFields = []string{"a", "b.c", "d[]", "e[].f", "g[][]", "h.i[].j"}
+
+func collectKeyValues(p *Parser, data []byte) ([]*KeyValue, error) {
+	ps, err := p.StartParse(data)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]*KeyValue, 0)
+	for {
+		kv, err := ps.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.IsEndOfRecord() {
+			return kvs, nil
+		}
+		kvs = append(kvs, kv)
+	}
+}
+
+var (
+	errReferenceSkipped  = errors.New("skipped")
+	errReferenceFinished = errors.New("finished")
+)
+
+func countResolvableEntries(t queriedFieldTable) int {
+	n := 0
+	for _, entry := range t {
+		if entry.isObject() {
+			n += countResolvableEntries(entry.children)
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+referenceKeyValues collects queried key/values with the tokenizer of encoding/json.
+*/
+func referenceKeyValues(data []byte, t queriedFieldTable) ([]*KeyValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	kvs := make([]*KeyValue, 0)
+	resolved := make(map[*queriedFieldEntry]bool)
+	pending := countResolvableEntries(t)
+	resolve := func(entry *queriedFieldEntry, inArray bool) error {
+		if inArray || resolved[entry] {
+			return nil
+		}
+		resolved[entry] = true
+		if pending--; pending == 0 {
+			return errReferenceFinished
+		}
+		return nil
+	}
+
+	var readValue func(entry *queriedFieldEntry, inArray bool) error
+	var readObject func(t queriedFieldTable, inArray bool) error
+	readObject = func(t queriedFieldTable, inArray bool) error {
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			entry := t[tok.(string)]
+			if err := readValue(entry, inArray); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token()
+		return err
+	}
+	readCompound := func(delim json.Delim, entry *queriedFieldEntry, inArray bool) error {
+		if delim == '{' {
+			if entry != nil && entry.isObject() {
+				return readObject(entry.children, inArray)
+			}
+			return readObject(queriedFieldTable{}, inArray)
+		}
+		for dec.More() {
+			var element *queriedFieldEntry
+			if entry != nil && entry.isArray() {
+				element = entry.element
+			}
+			if err := readValue(element, true); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if entry != nil && entry.isArray() {
+			return resolve(entry, inArray)
+		}
+		return nil
+	}
+	readValue = func(entry *queriedFieldEntry, inArray bool) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			start := dec.InputOffset() - 1
+			if err := readCompound(delim, entry, inArray); err != nil {
+				return err
+			}
+			if entry == nil || !entry.isAtomic() {
+				return nil
+			}
+			// objects and arrays of atomic queried fields are raw values
+			kv := &KeyValue{FieldID: entry.id, Type: JSONObject, RawValue: string(data[start:dec.InputOffset()])}
+			if delim == '[' {
+				kv.Type = JSONArray
+			}
+			kvs = append(kvs, kv)
+			return resolve(entry, inArray)
+		}
+
+		if entry == nil || !entry.isAtomic() {
+			return nil
+		}
+		kv := &KeyValue{FieldID: entry.id}
+		switch v := tok.(type) {
+		case nil:
+			kv.Type = JSONNull
+		case bool:
+			kv.Type = JSONBool
+			kv.Value = v
+		case string:
+			kv.Type = JSONString
+			kv.Value = v
+		case json.Number:
+			f, err := strconv.ParseFloat(string(v), 64)
+			if err != nil {
+				return errReferenceSkipped
+			}
+			kv.Type = JSONNumber
+			kv.Value = f
+		}
+		kvs = append(kvs, kv)
+		return resolve(entry, inArray)
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, errReferenceSkipped
+	}
+	if err := readObject(t, false); err == errReferenceFinished {
+		return kvs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errReferenceSkipped
+	}
+	return kvs, nil
+}
+
+func FuzzParserState(f *testing.F) {
+	seeds := []string{
+		`{"a":1,"b":{"c":"x"},"d":[true,null,"y"]}`,
+		`{"e":[{"f":1},{"f":{"g":2}},3],"g":[[1,[2]],[],"x"]}`,
+		`{"h":{"i":[{"j":"あ😀"},{"k":1}]},"a":-1.5e3}`,
+		`{"a":"\"\\\/\b\f\n\r\t","a":2}`,
+		`{ "a" : [ ] , "d" : [ 1 , 2 ] }`,
+		`{"a":1}}`,
+		`{"a":{"b":[1,{}]},"d":[[],{"f":1}],"e":[{"f":[ 2 ]}]}`,
+		`{"a":[1,}`,
+		`{"\\":"\\\"","b":{"c":[{]}}}`,
+		`[1,2]`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	p, err := NewParser(fuzzQueriedFields)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		actual, err := collectKeyValues(p, data)
+		if !json.Valid(data) || !utf8.Valid(data) {
+			return
+		}
+
+		expected, refErr := referenceKeyValues(data, p.queriedFieldTable)
+		if refErr != nil {
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", data, err)
+		}
+		if len(expected) != len(actual) {
+			t.Fatalf("length mismatch for %q: expected %d, actual %d", data, len(expected), len(actual))
+		}
+		for i := range expected {
+			e, a := expected[i], actual[i]
+			mismatch := e.FieldID != a.FieldID || e.Type != a.Type
+			if e.Type == JSONObject || e.Type == JSONArray {
+				mismatch = mismatch || e.RawValue != a.RawValue
+			} else {
+				mismatch = mismatch || e.Value != a.Value
+			}
+			mismatch = mismatch || string(data[a.Start:a.End]) != a.RawValue || data[a.KeyOffset] != '"'
+			if mismatch {
+				t.Fatalf("mismatch at %d for %q: expected %+v, actual %+v", i, data, e, a)
+			}
+		}
+	})
+}
+
+func FuzzStreamParserState(f *testing.F) {
+	seeds := []string{
+		`{"a":1,"b":{"c":"x"},"d":[true,null,"y"]}`,
+		`{"e":[{"f":1},{"f":{"g":2}},3],"g":[[1,[2]],[],"x"]}`,
+		`{"a":{"x":"}]"},"h":{"i":[{"j":"あ😀"},{"k":1}]}}`,
+		`{"x"{"y"}:1}`,
+		`{"x"[` + strings.Repeat(" ", 200) + `]:1}`,
+		`{"a":[1,}`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	p, err := NewParser(fuzzQueriedFields)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunkSizes := []int{1, 64}
+		actual := make([][]*KeyValue, len(chunkSizes))
+		errs := make([]error, len(chunkSizes))
+		for i, chunkSize := range chunkSizes {
+			actual[i], errs[i] = collectStreamKeyValues(p.StartStreamParse(bytes.NewReader(data), chunkSize))
+		}
+		if !json.Valid(data) || !utf8.Valid(data) {
+			return
+		}
+
+		expected, err := collectKeyValues(p, data)
+		if err != nil {
+			return
+		}
+		for i, chunkSize := range chunkSizes {
+			if errs[i] != nil {
+				t.Fatalf("unexpected error for %q (chunkSize=%d): %v", data, chunkSize, errs[i])
+			}
+			if !reflect.DeepEqual(expected, actual[i]) {
+				t.Fatalf("mismatch for %q (chunkSize=%d): expected %+v, actual %+v", data, chunkSize, expected, actual[i])
+			}
+		}
+	})
+}
+
+func FuzzUnescapeString(f *testing.F) {
+	seeds := []string{`abc`, `\"\\\/\b\f\n\r\t`, `あ`, `😀`, `\ud83d`, `\x`, `\u12`}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		actual, err := unescapeString(body, literalOptions{})
+		if !utf8.Valid(body) {
+			return
+		}
+
+		var expected string
+		quoted := append(append([]byte{'"'}, body...), '"')
+		if json.Unmarshal(quoted, &expected) != nil {
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", body, err)
+		}
+		if expected != actual {
+			t.Fatalf("mismatch for %q: expected %q, actual %q", body, expected, actual)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package mison
+
+import "fmt"
+
+/*
+StructuralIndex is the structural index of a record, which is the low-level API to traverse records with the bitmaps of Mison.
+
+Level i of the leveled bitmaps has the colons and commas which are nested at most i+1 times in objects and arrays,
+so the fields of an object nested d times are enumerated by level d-1 in the range of the object.
+See section 4.2.
+*/
+type StructuralIndex struct {
+	index   structualIndex
+	buffers indexBuffers
+}
+
+/*
+NewStructuralIndex builds the structural index of json with the given number of levels.
+*/
+func NewStructuralIndex(json []byte, level int) (*StructuralIndex, error) {
+	if level <= 0 {
+		return nil, fmt.Errorf("level must be positive, but got %d", level)
+	}
+	index := &StructuralIndex{index: structualIndex{level: level}}
+	if err := index.Reset(json); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+/*
+Reset rebuilds the index for json with the same number of levels, reusing the bitmaps for the previous record.
+When the build fails, the index is cleared to an empty record.
+*/
+func (index *StructuralIndex) Reset(json []byte) error {
+	if err := index.index.build(json, index.index.level, nil, &index.buffers); err != nil {
+		index.clear()
+		return err
+	}
+	return nil
+}
+
+/*
+clear makes the index empty, so that accessors do not refer bitmaps partially built for another record.
+*/
+func (index *StructuralIndex) clear() {
+	index.index.json = nil
+	index.index.stringMaskBitmap = index.index.stringMaskBitmap[:0]
+	for i := range index.index.leveledColonBitmaps {
+		index.index.leveledColonBitmaps[i] = index.index.leveledColonBitmaps[i][:0]
+		index.index.leveledCommaBitmaps[i] = index.index.leveledCommaBitmaps[i][:0]
+	}
+	index.buffers.bitmaps.resize(0)
+	index.buffers.quotes = index.buffers.quotes[:0]
+}
+
+// Record returns the record of the index
+func (index *StructuralIndex) Record() []byte {
+	return index.index.json
+}
+
+// Level returns the number of levels of the leveled bitmaps
+func (index *StructuralIndex) Level() int {
+	return index.index.level
+}
+
+/*
+AppendColons appends the positions of the colons in [start, end] of the level to dst.
+The range is clipped to the record, and dst is returned unchanged if level is not in [0, Level()).
+*/
+func (index *StructuralIndex) AppendColons(dst []int, level, start, end int) []int {
+	return index.appendPositions(dst, index.index.leveledColonBitmaps, level, start, end)
+}
+
+/*
+AppendCommas appends the positions of the commas in [start, end] of the level to dst.
+The range is clipped to the record, and dst is returned unchanged if level is not in [0, Level()).
+*/
+func (index *StructuralIndex) AppendCommas(dst []int, level, start, end int) []int {
+	return index.appendPositions(dst, index.index.leveledCommaBitmaps, level, start, end)
+}
+
+func (index *StructuralIndex) appendPositions(dst []int, bitmaps [][]uint64, level, start, end int) []int {
+	if level < 0 || level >= index.index.level || level >= len(bitmaps) {
+		return dst
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end {
+		return dst
+	}
+	return appendColonPositions(dst, bitmaps, start, end, level)
+}
+
+/*
+FieldName returns the decoded name of the field whose colon is at colon.
+The returned slice refers the record when the name has no escape sequence.
+*/
+func (index *StructuralIndex) FieldName(colon int) ([]byte, error) {
+	json := index.index.json
+	if colon < 0 || colon >= len(json) || json[colon] != ':' || index.InString(colon) {
+		return nil, fmt.Errorf("no colon at %d", colon)
+	}
+	return retrieveFieldName(index.index.json, index.index.stringMaskBitmap, colon, literalOptions{})
+}
+
+/*
+MatchingClose returns the position of the right brace or bracket which closes the left one at open.
+*/
+func (index *StructuralIndex) MatchingClose(open int) (int, error) {
+	json := index.index.json
+	if open < 0 || open >= len(json) || (json[open] != '{' && json[open] != '[') || index.InString(open) {
+		return -1, fmt.Errorf("no left brace or bracket at %d", open)
+	}
+
+	// the bitmaps of structural characters are already masked by the string mask
+	b := &index.buffers.bitmaps
+	depth := 0
+	for i := open / wordSize; i < len(index.index.stringMaskBitmap); i++ {
+		openers := b.lBraces[i] | b.lBrackets[i]
+		m := openers | b.rBraces[i] | b.rBrackets[i]
+		if i == open/wordSize {
+			m &= ^uint64(0) << uint(open%wordSize)
+		}
+		for m != 0 {
+			bit := extractRightmost1(m)
+			if openers&bit != 0 {
+				depth++
+			} else if depth--; depth == 0 {
+				return bitPosition(i, bit), nil
+			}
+			m = removeRightmost1(m)
+		}
+	}
+
+	// unreachable because unclosed characters are rejected on building
+	return -1, fmt.Errorf("no right brace or bracket for %d", open)
+}
+
+/*
+InString reports whether the byte at offset is a part of a string including the quotes.
+*/
+func (index *StructuralIndex) InString(offset int) bool {
+	if offset < 0 || offset >= len(index.index.json) {
+		return false
+	}
+	i, bit := offset/wordSize, uint64(1)<<uint(offset%wordSize)
+	return (index.index.stringMaskBitmap[i]|index.buffers.quotes[i])&bit != 0
+}
@@ -0,0 +1,181 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStructuralIndex(t *testing.T) {
+	record := []byte(`{"a":{"b\"":"x:{","c":[1,{"d":2}]}, "e" : [] }`)
+	index, err := NewStructuralIndex(record, 3)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, record, index.Record())
+	assert.Equal(t, 3, index.Level())
+
+	rootColons := index.AppendColons(nil, 0, 0, len(record)-1)
+	assert.Equal(t, []int{4, 40}, rootColons)
+	assert.Equal(t, []int{34}, index.AppendCommas(nil, 0, 0, len(record)-1))
+
+	names := make([]string, 0)
+	for _, colon := range rootColons {
+		name, err := index.FieldName(colon)
+		if assert.NoError(t, err) {
+			names = append(names, string(name))
+		}
+	}
+	assert.Equal(t, []string{"a", "e"}, names)
+
+	closing, err := index.MatchingClose(5)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 33, closing)
+		colons := index.AppendColons(nil, 1, 5, closing)
+		assert.Equal(t, []int{11, 21}, colons)
+		name, err := index.FieldName(colons[0])
+		if assert.NoError(t, err) {
+			assert.Equal(t, `b"`, string(name))
+		}
+	}
+	closing, err = index.MatchingClose(22)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 32, closing)
+		assert.Equal(t, []int{24}, index.AppendCommas(nil, 2, 22, closing))
+	}
+
+	assert.True(t, index.InString(6))
+	assert.True(t, index.InString(14))
+	assert.True(t, index.InString(18))
+	assert.True(t, index.InString(20))
+	assert.False(t, index.InString(5))
+	assert.False(t, index.InString(21))
+	assert.Equal(t, []int{4, 40}, index.AppendColons(nil, 0, -10, len(record)+100))
+	assert.Equal(t, []int{34}, index.AppendCommas([]int{}, 0, -1, len(record)))
+	for _, level := range []int{-1, 3, 4} {
+		dst := []int{-1}
+		assert.Equal(t, dst, index.AppendColons(dst, level, 0, len(record)-1), "%d", level)
+		assert.Equal(t, dst, index.AppendCommas(dst, level, 0, len(record)-1), "%d", level)
+	}
+	assert.Empty(t, index.AppendColons(nil, 0, 10, 5))
+	assert.Empty(t, index.AppendCommas(nil, 0, -5, -1))
+
+	assert.False(t, index.InString(-1))
+	assert.False(t, index.InString(len(record)))
+
+	for _, open := range []int{-1, 1, 15, len(record)} {
+		_, err := index.MatchingClose(open)
+		assert.Error(t, err, "%d", open)
+	}
+
+	_, err = NewStructuralIndex(record, 0)
+	assert.Error(t, err)
+	_, err = NewStructuralIndex([]byte(`{"a":[}`), 1)
+	assert.True(t, errors.Is(err, ErrUnexpectedClosing), "%v", err)
+
+	for _, colon := range []int{-1, 0, 6, 7, 13, len(record), 100} {
+		_, err := index.FieldName(colon)
+		assert.Error(t, err, "%d", colon)
+	}
+
+	if assert.NoError(t, index.Reset([]byte(`{"x":1}`))) {
+		assert.Equal(t, []int{4}, index.AppendColons(nil, 0, 0, 6))
+		for _, colon := range []int{-1, 5, 6, 100} {
+			_, err := index.FieldName(colon)
+			assert.Error(t, err, "%d", colon)
+		}
+	}
+}
+
+func TestStructuralIndexFailedReset(t *testing.T) {
+	record := []byte(`{"a":"` + strings.Repeat("x", 300) + `","b":[1,{"c":2}]}`)
+	for _, invalid := range []string{`{]`, `{"a`, `{"a":[}`} {
+		t.Run(invalid, func(t *testing.T) {
+			index, err := NewStructuralIndex(record, 2)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Error(t, index.Reset([]byte(invalid)))
+
+			assert.Empty(t, index.Record())
+			for _, offset := range []int{0, 1, 200, len(record) - 1} {
+				assert.False(t, index.InString(offset), "%d", offset)
+				_, err := index.MatchingClose(offset)
+				assert.Error(t, err, "%d", offset)
+				_, err = index.FieldName(offset)
+				assert.Error(t, err, "%d", offset)
+			}
+			assert.Empty(t, index.AppendColons(nil, 0, 0, len(record)-1))
+			assert.Empty(t, index.AppendCommas(nil, 1, 0, len(record)-1))
+
+			if assert.NoError(t, index.Reset(record)) {
+				assert.Equal(t, []int{4, 311}, index.AppendColons(nil, 0, 0, len(record)-1))
+				assert.True(t, index.InString(200))
+			}
+		})
+	}
+}
+
+/*
+scanStrings returns whether each byte of json is a part of a string and the matching closing character of each opening one.
+*/
+func scanStrings(json []byte) ([]bool, map[int]int) {
+	inString := make([]bool, len(json))
+	closes := make(map[int]int)
+	stack := make([]int, 0)
+	quoted, escaped := false, false
+	for i, c := range json {
+		switch {
+		case quoted:
+			inString[i] = true
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				quoted = false
+			}
+		case c == '"':
+			inString[i] = true
+			quoted = true
+		case c == '{' || c == '[':
+			stack = append(stack, i)
+		case c == '}' || c == ']':
+			closes[stack[len(stack)-1]] = i
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return inString, closes
+}
+
+func TestStructuralIndexMatchesScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		var b strings.Builder
+		b.WriteByte('{')
+		randomJSONValue(r, &b, 6)
+		b.WriteByte('}')
+		record := []byte(`{"a":` + b.String() + `}`)
+
+		index, err := NewStructuralIndex(record, 2)
+		if !assert.NoError(t, err, "%s", record) {
+			return
+		}
+		inString, closes := scanStrings(record)
+		for i := range record {
+			if !assert.Equal(t, inString[i], index.InString(i), fmt.Sprintf("%d in %s", i, record)) {
+				return
+			}
+			if expected, ok := closes[i]; ok {
+				actual, err := index.MatchingClose(i)
+				if !assert.NoError(t, err) || !assert.Equal(t, expected, actual, fmt.Sprintf("%d in %s", i, record)) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package mison
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+parseLiteralRegexp is the former implementation of parseLiteral with regexp, which is kept as the reference of the scanner.
+*/
+func parseLiteralRegexp(json []byte, colon int, options literalOptions) (interface{}, string, JSONType, error) {
+	i := colon + 1
+	size := len(json)
+	i = skipBlanks(json, i)
+
+	if i == size {
+		return nil, "", JSONUnknown, syntaxErrorAt(json, i, ErrValueNotFound, "value is not found")
+	}
+
+	if json[i] == '{' {
+		return nil, "", JSONUnknown, errUnexpectedObject
+	} else if json[i] == '[' {
+		return nil, "", JSONUnknown, errUnexpectedArray
+	}
+
+	r := regexp.MustCompile(`\A(true|false|null|-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?|"([^\\\n"]|\\.)*")`)
+	literal := r.Find(json[i:size])
+	if literal == nil {
+		return nil, "", JSONUnknown, syntaxErrorAt(json, i, ErrInvalidValue, "invalid value")
+	}
+
+	if j := skipBlanks(json, i+len(literal)); j < size && json[j] != ',' && json[j] != '}' && json[j] != ']' {
+		return nil, "", JSONUnknown, syntaxErrorAt(json, j, ErrInvalidValue, "unexpected character after value")
+	}
+
+	var t JSONType
+	var v interface{}
+	switch literal[0] {
+	case 't', 'f':
+		t = JSONBool
+		v = literal[0] == 't'
+	case 'n':
+		t = JSONNull
+		v = nil
+	case '"':
+		t = JSONString
+		var err error
+		v, err = unescapeString(literal[1:len(literal)-1], options)
+		if err != nil {
+			return nil, "", JSONUnknown, stringSyntaxError(json, i+1, err)
+		}
+	default:
+		t = JSONNumber
+		var err error
+		v, err = strconv.ParseFloat(string(literal), 64)
+		if err != nil {
+			return nil, "", JSONUnknown, syntaxErrorAt(json, i, ErrNumberOutOfRange, "number cannot be represented as float64")
+		}
+	}
+
+	return v, string(literal), t, nil
+}
+
+func TestParseLiteralMatchesRegexp(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	alphabet := []string{"0", "1", "9", "-", "+", ".", "e", "E", `"`, `\`, `\"`, `é`, "\n", "\t", " ", ",", "}", "]", "a", "true", "false", "null", "\xff"}
+	for trial := 0; trial < 20000; trial++ {
+		json := []byte{':'}
+		for n := r.Intn(10); n > 0; n-- {
+			json = append(json, alphabet[r.Intn(len(alphabet))]...)
+		}
+
+		expectedV, expectedRV, expectedT, expectedErr := parseLiteralRegexp(json, 0, literalOptions{})
+		actualV, actualRV, actualT, actualErr := parseLiteral(json, 0, literalOptions{})
+		if !assert.Equal(t, expectedErr, actualErr, "%q", json) ||
+			!assert.Equal(t, expectedV, actualV, "%q", json) ||
+			!assert.Equal(t, expectedRV, actualRV, "%q", json) ||
+			!assert.Equal(t, expectedT, actualT, "%q", json) {
+			return
+		}
+	}
+}
+
+func TestScanQueriedFieldName(t *testing.T) {
+	namePattern := regexp.MustCompile(`^([^][.\\]|\\.)+`)
+	escapePattern := regexp.MustCompile(`\\(.)`)
+
+	r := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "é", ".", "[", "]", `\`, "\n", "[]"}
+	for trial := 0; trial < 10000; trial++ {
+		field := ""
+		for n := r.Intn(8); n > 0; n-- {
+			field += alphabet[r.Intn(len(alphabet))]
+		}
+
+		expected := 0
+		if loc := namePattern.FindStringIndex(field); loc != nil {
+			expected = loc[1]
+		}
+		if !assert.Equal(t, expected, scanQueriedFieldName(field), "%q", field) ||
+			!assert.Equal(t, escapePattern.ReplaceAllString(field, `$1`), unescapeQueriedFieldName(field), "%q", field) {
+			return
+		}
+	}
+}
+
+func BenchmarkParseLiteral(b *testing.B) {
+	literals := []string{`12345`, `-1.25e+10`, `true`, `null`, `"short"`, `"a longer string value without escapes in it"`, `"escaped \"string\" é"`}
+	implementations := []struct {
+		name  string
+		parse func(json []byte, colon int, options literalOptions) (interface{}, string, JSONType, error)
+	}{
+		{name: "regexp", parse: parseLiteralRegexp},
+		{name: "scanner", parse: parseLiteral},
+	}
+
+	for _, literal := range literals {
+		json := []byte(`:` + literal + `,"next":1}`)
+		for _, impl := range implementations {
+			b.Run(fmt.Sprintf("%s/%s", literal, impl.name), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(literal)))
+				for i := 0; i < b.N; i++ {
+					if _, _, _, err := impl.parse(json, 0, literalOptions{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -1,29 +1,45 @@
 package mison
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"math/bits"
-	"regexp"
-	"strconv"
+	"runtime"
 	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type structualIndex struct {
 	json                []byte
 	level               int
-	stringMaskBitmap    []uint32
-	leveledColonBitmaps [][]uint32
+	stringMaskBitmap    []uint64
+	leveledColonBitmaps [][]uint64
+	leveledCommaBitmaps [][]uint64
 }
 
+/*
+indexBuffers holds the intermediate bitmaps of building structural indices, which are reused across records.
+*/
+type indexBuffers struct {
+	bitmaps structualCharacterBitmaps
+	quotes  []uint64
+	stack   maskStack
+}
+
+// wordSize is the number of bits in a word of bitmaps
+const wordSize = 64
+
 /*
 removeRightmost1 removes the rightmost 1 in x.
 
 E.g.
 	11101000 -> 11100000
 */
-func removeRightmost1(x uint32) uint32 {
+func removeRightmost1(x uint64) uint64 {
 	return x & (x - 1)
 }
 
@@ -33,7 +49,7 @@ extractRightmost1 extract the rightmost 1 in x.
 E.g.
 	11101000 -> 00001000
 */
-func extractRightmost1(x uint32) uint32 {
+func extractRightmost1(x uint64) uint64 {
 	return x & -x
 }
 
@@ -43,78 +59,141 @@ smearRightmost1 extract the rightmost 1 in x and smear it to the right.
 E.g.
 	11101000 -> 00001111
 */
-func smearRightmost1(x uint32) uint32 {
+func smearRightmost1(x uint64) uint64 {
 	return x ^ (x - 1)
 }
 
-func popcnt(x uint32) int {
-	return bits.OnesCount32(x)
+func popcnt(x uint64) int {
+	return bits.OnesCount64(x)
+}
+
+/*
+bitPosition returns the position in json of the only 1 in the bit of i-th word.
+*/
+func bitPosition(i int, bit uint64) int {
+	return i*wordSize + bits.TrailingZeros64(bit)
+}
+
+/*
+resizeBitmap returns a bitmap of n words reusing bitmap when it has enough capacity.
+The contents are not cleared.
+*/
+func resizeBitmap(bitmap []uint64, n int) []uint64 {
+	if cap(bitmap) < n {
+		return make([]uint64, n)
+	}
+	return bitmap[:n]
 }
 
 /*
 structualCharacterBitmaps represents set of bitmap for structual character.
 */
 type structualCharacterBitmaps struct {
-	backslashes []uint32
-	quotes      []uint32
-	colons      []uint32
-	lBraces     []uint32
-	rBraces     []uint32
-	commas      []uint32
-	lBrackets   []uint32
-	rBrackets   []uint32
+	backslashes []uint64
+	quotes      []uint64
+	colons      []uint64
+	lBraces     []uint64
+	rBraces     []uint64
+	commas      []uint64
+	lBrackets   []uint64
+	rBrackets   []uint64
+}
+
+const (
+	swarOnes = 0x0101010101010101
+	swarLow7 = 0x7f7f7f7f7f7f7f7f
+	// swarGather gathers the most significant bits of 8 bytes into the highest byte
+	swarGather = 0x0002040810204081
+)
+
+/*
+matchBytes returns the 8 bit mask of bytes in v (little endian) which are equal to c.
+*/
+func matchBytes(v uint64, c byte) uint64 {
+	x := v ^ (swarOnes * uint64(c))
+	// the most significant bit of each byte is 1 iff the byte of x is 0
+	zeros := ^(((x & swarLow7) + swarLow7) | x | swarLow7)
+	return (zeros * swarGather) >> 56
 }
 
 /*
 buildStructualCharacterBitmaps builda structual character bitmaps.
 
-See section 4.2.1 (currently, SIMD is not used).
+The full blocks are classified with AVX2 when it is available,
+and the rest are classified 8 bytes at a time with SWAR (SIMD within a register).
+See section 4.2.1.
 */
 func buildStructualCharacterBitmaps(json []byte) *structualCharacterBitmaps {
-	indices := map[byte]int{'\\': 0, '"': 1, ':': 2, '{': 3, '}': 4, ',': 5, '[': 6, ']': 7}
-	jsonLen := len(json)
-	bitmapLen := (jsonLen-1)/32 + 1
-	bitmaps := [][]uint32{
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-		make([]uint32, bitmapLen),
-	}
+	bitmaps := &structualCharacterBitmaps{}
+	bitmaps.resize((len(json)-1)/wordSize + 1)
+	classifyStructualCharacters(json, bitmaps)
+	return bitmaps
+}
 
-	for i := 0; i < bitmapLen; i++ {
-		sublen := jsonLen - i*32
-		if sublen > 32 {
-			sublen = 32
-		}
-		for _, x := range json[i*32 : i*32+sublen] {
-			for _, bitmap := range bitmaps {
-				bitmap[i] >>= 1
-			}
+/*
+resize resizes the bitmaps to n words reusing the underlying arrays.
+*/
+func (b *structualCharacterBitmaps) resize(n int) {
+	b.backslashes = resizeBitmap(b.backslashes, n)
+	b.quotes = resizeBitmap(b.quotes, n)
+	b.colons = resizeBitmap(b.colons, n)
+	b.lBraces = resizeBitmap(b.lBraces, n)
+	b.rBraces = resizeBitmap(b.rBraces, n)
+	b.commas = resizeBitmap(b.commas, n)
+	b.lBrackets = resizeBitmap(b.lBrackets, n)
+	b.rBrackets = resizeBitmap(b.rBrackets, n)
+}
 
-			j, ok := indices[x]
-			if ok {
-				bitmaps[j][i] |= 1 << 31
-			}
+/*
+classifyStructualCharacters fills bitmaps with the structual characters of json.
+*/
+func classifyStructualCharacters(json []byte, bitmaps *structualCharacterBitmaps) {
+	bitmapLen := len(bitmaps.quotes)
+	var tail [wordSize]byte
+	for i := classifyStructualCharactersSIMD(json, bitmaps); i < bitmapLen; i++ {
+		block := json[i*wordSize:]
+		if len(block) < wordSize {
+			// zero never matches with structual characters
+			copy(tail[:], block)
+			block = tail[:]
 		}
 
-		for _, bitmap := range bitmaps {
-			bitmap[i] >>= uint(32 - sublen)
+		var backslashes, quotes, colons, lBraces, rBraces, commas, lBrackets, rBrackets uint64
+		for k := uint(0); k < wordSize/8; k++ {
+			v := binary.LittleEndian.Uint64(block[k*8:])
+			backslashes |= matchBytes(v, '\\') << (k * 8)
+			quotes |= matchBytes(v, '"') << (k * 8)
+			colons |= matchBytes(v, ':') << (k * 8)
+			lBraces |= matchBytes(v, '{') << (k * 8)
+			rBraces |= matchBytes(v, '}') << (k * 8)
+			commas |= matchBytes(v, ',') << (k * 8)
+			lBrackets |= matchBytes(v, '[') << (k * 8)
+			rBrackets |= matchBytes(v, ']') << (k * 8)
 		}
+		bitmaps.backslashes[i] = backslashes
+		bitmaps.quotes[i] = quotes
+		bitmaps.colons[i] = colons
+		bitmaps.lBraces[i] = lBraces
+		bitmaps.rBraces[i] = rBraces
+		bitmaps.commas[i] = commas
+		bitmaps.lBrackets[i] = lBrackets
+		bitmaps.rBrackets[i] = rBrackets
 	}
+}
 
+/*
+slice returns the bitmaps of the words from from to to, which share the underlying arrays with b.
+*/
+func (b *structualCharacterBitmaps) slice(from, to int) *structualCharacterBitmaps {
 	return &structualCharacterBitmaps{
-		backslashes: bitmaps[indices['\\']],
-		quotes:      bitmaps[indices['"']],
-		colons:      bitmaps[indices[':']],
-		lBraces:     bitmaps[indices['{']],
-		rBraces:     bitmaps[indices['}']],
-		commas:      bitmaps[indices[',']],
-		lBrackets:   bitmaps[indices['[']],
-		rBrackets:   bitmaps[indices[']']],
+		backslashes: b.backslashes[from:to],
+		quotes:      b.quotes[from:to],
+		colons:      b.colons[from:to],
+		lBraces:     b.lBraces[from:to],
+		rBraces:     b.rBraces[from:to],
+		commas:      b.commas[from:to],
+		lBrackets:   b.lBrackets[from:to],
+		rBrackets:   b.rBrackets[from:to],
 	}
 }
 
@@ -123,83 +202,127 @@ buildStructualQuoteBitmaps builds structual quote bitmaps.
 
 See section 4.2.2.
 */
-func buildStructualQuoteBitmap(bitmaps *structualCharacterBitmaps) []uint32 {
+func buildStructualQuoteBitmap(bitmaps *structualCharacterBitmaps) ([]uint64, error) {
+	structualQuotes := make([]uint64, len(bitmaps.quotes))
+	if err := fillStructualQuoteBitmap(bitmaps, structualQuotes); err != nil {
+		return nil, err
+	}
+	return structualQuotes, nil
+}
+
+/*
+fillStructualQuoteBitmap fills structualQuotes with the structual quotes in bitmaps.
+*/
+func fillStructualQuoteBitmap(bitmaps *structualCharacterBitmaps, structualQuotes []uint64) error {
 	backslashes := bitmaps.backslashes
 	quotes := bitmaps.quotes
 	bitmapLen := len(backslashes)
-	backsalashedQuotes := make([]uint32, bitmapLen)
-	for i := 0; i < bitmapLen-1; i++ {
-		backsalashedQuotes[i] = ((quotes[i] >> 1) | (quotes[i+1] << 31)) & backslashes[i]
-	}
-	backsalashedQuotes[bitmapLen-1] = (quotes[bitmapLen-1] >> 1) & backslashes[bitmapLen-1]
-
-	unstructualQuotes := make([]uint32, bitmapLen)
+	// unstructualQuotes of the previous word
+	prevUnstructualQuotes := ^uint64(0)
 	for i := 0; i < bitmapLen; i++ {
-		var unstructualQuote uint32
-		backsalashedQuote := backsalashedQuotes[i]
+		var nextQuotes uint64
+		if i+1 < bitmapLen {
+			nextQuotes = quotes[i+1]
+		}
+		var unstructualQuote uint64
+		backsalashedQuote := ((quotes[i] >> 1) | (nextQuotes << (wordSize - 1))) & backslashes[i]
 		for backsalashedQuote != 0 {
 			mask := smearRightmost1(backsalashedQuote)
 			numberOfOnes := popcnt(mask)
-			backslashOnLeft := (backslashes[i] & mask) << uint(32-numberOfOnes)
-			numberOfLeadingOnes := bits.LeadingZeros32(^backslashOnLeft)
+			backslashOnLeft := (backslashes[i] & mask) << uint(wordSize-numberOfOnes)
+			numberOfLeadingOnes := bits.LeadingZeros64(^backslashOnLeft)
 			if numberOfLeadingOnes == numberOfOnes {
 				for j := i - 1; j >= 0; j-- {
-					numberOfLeadingOneInWord := bits.LeadingZeros32(^backslashes[j])
-					if numberOfLeadingOneInWord < 32 {
+					numberOfLeadingOneInWord := bits.LeadingZeros64(^backslashes[j])
+					if numberOfLeadingOneInWord < wordSize {
 						numberOfLeadingOnes += numberOfLeadingOneInWord
 						break
 					}
 				}
 			} else if numberOfLeadingOnes > numberOfOnes {
-				panic("illigal state about of bitmask")
+				return fmt.Errorf("illegal state of backslash bitmap at word %d", i)
 			}
 			if numberOfLeadingOnes&1 == 1 {
 				unstructualQuote = unstructualQuote | extractRightmost1(backsalashedQuote)
 			}
 			backsalashedQuote = removeRightmost1(backsalashedQuote)
 		}
-		unstructualQuotes[i] = ^unstructualQuote
+		unstructualQuotes := ^unstructualQuote
+		structualQuotes[i] = quotes[i] & ((unstructualQuotes << 1) | (prevUnstructualQuotes >> (wordSize - 1)))
+		prevUnstructualQuotes = unstructualQuotes
 	}
+	return nil
+}
 
-	structualQuotes := make([]uint32, bitmapLen)
-	structualQuotes[0] = quotes[0] & (unstructualQuotes[0] << 1)
-	for i := 1; i < bitmapLen; i++ {
-		structualQuotes[i] = quotes[i] & ((unstructualQuotes[i] << 1) | (unstructualQuotes[i-1] >> 31))
-	}
-	return structualQuotes
+// evenBits has 1 at even positions of a word
+const evenBits = 0x5555555555555555
+
+/*
+escapedBits returns the bitmap of characters escaped by backslashes.
+carry is 1 when the first character of the word is escaped by the previous word, and it is updated for the next word.
+*/
+func escapedBits(backslashes uint64, carry *uint64) uint64 {
+	// the backslash escaped by the previous word does not escape
+	backslashes &^= *carry
+	followsEscape := backslashes<<1 | *carry
+	oddSequenceStarts := backslashes &^ evenBits &^ followsEscape
+	sequencesStartingOnEvenBits, overflow := bits.Add64(oddSequenceStarts, backslashes, 0)
+	*carry = overflow
+	invertMask := sequencesStartingOnEvenBits << 1
+	return (evenBits ^ invertMask) & followsEscape
+}
+
+/*
+prefixXor returns the prefix XOR of x, whose i-th bit is the XOR of bits 0 to i of x.
+*/
+func prefixXor(x uint64) uint64 {
+	x ^= x << 1
+	x ^= x << 2
+	x ^= x << 4
+	x ^= x << 8
+	x ^= x << 16
+	x ^= x << 32
+	return x
 }
 
 /*
 buildStringMaskBitmap builds string mask bitmap.
 
+The string mask of each word is the prefix XOR of the quotes, which is computed in constant time
+with a shift cascade (or PCLMULQDQ when it is available).
+Whether the end of the word is in a string is carried to the next word.
 See section 4.2.3.
 */
-func buildStringMaskBitmap(quoteBitmaps []uint32) []uint32 {
-	// return []uint32{}
+func buildStringMaskBitmap(quoteBitmaps []uint64) []uint64 {
+	stringBitmap := make([]uint64, len(quoteBitmaps))
+	fillStringMaskBitmap(quoteBitmaps, stringBitmap)
+	return stringBitmap
+}
+
+/*
+fillStringMaskBitmap fills stringBitmap with the string mask of quoteBitmaps, which starts out of strings.
+*/
+func fillStringMaskBitmap(quoteBitmaps, stringBitmap []uint64) {
 	bitmapLen := len(quoteBitmaps)
-	n := 0
-	stringBitmap := make([]uint32, bitmapLen)
+	if buildStringMaskBitmapSIMD(quoteBitmaps, stringBitmap) {
+		return
+	}
+
+	// all 1 when the previous word ends in a string
+	var inString uint64
 	for i := 0; i < bitmapLen; i++ {
-		quoteMask := quoteBitmaps[i]
-		var stringMask uint32
-		for quoteMask != 0 {
-			mask := smearRightmost1(quoteMask)
-			stringMask ^= mask
-			quoteMask = removeRightmost1(quoteMask)
-			n++
-		}
-		if n%2 == 1 {
-			stringMask = ^stringMask
-		}
-		stringBitmap[i] = stringMask
+		quotes := quoteBitmaps[i]
+		prefix := prefixXor(quotes) ^ inString
+		// the opening quotes are not in strings, but the closing quotes are
+		stringBitmap[i] = prefix ^ quotes
+		inString = uint64(int64(prefix) >> (wordSize - 1))
 	}
-	return stringBitmap
 }
 
 type maskStack struct {
 	body []struct {
 		index int
-		mask  uint32
+		mask  uint64
 	}
 	sp int
 }
@@ -210,17 +333,17 @@ func newMaskStack() *maskStack {
 	return &maskStack{
 		body: make([]struct {
 			index int
-			mask  uint32
+			mask  uint64
 		}, stackInitialSize),
 		sp: 0,
 	}
 }
 
-func (s *maskStack) push(index int, mask uint32) error {
+func (s *maskStack) push(index int, mask uint64) error {
 	if s.sp == len(s.body) {
 		s.body = append(s.body, struct {
 			index int
-			mask  uint32
+			mask  uint64
 		}{})
 	}
 
@@ -230,7 +353,7 @@ func (s *maskStack) push(index int, mask uint32) error {
 	return nil
 }
 
-func (s *maskStack) pop() (int, uint32, error) {
+func (s *maskStack) pop() (int, uint64, error) {
 	if s.sp == 0 {
 		return 0, 0, errors.New("attempt pop from empty stack")
 	}
@@ -239,30 +362,105 @@ func (s *maskStack) pop() (int, uint32, error) {
 	return s.body[s.sp].index, s.body[s.sp].mask, nil
 }
 
-func buildLeveledColonBitmaps(bitmaps *structualCharacterBitmaps, stringMaskBitmap []uint32, level int) ([][]uint32, error) {
+/*
+indexLevels represents the levels of the leveled bitmaps which are used by queried fields.
+*/
+type indexLevels struct {
+	colons []bool
+	commas []bool
+}
+
+/*
+newIndexLevels returns the levels of the leveled bitmaps which are used to parse the fields of t.
+Colons are used to find fields in objects, and commas are used to find the end of compound values and elements of arrays.
+*/
+func newIndexLevels(t queriedFieldTable, level int) *indexLevels {
+	levels := &indexLevels{colons: make([]bool, level), commas: make([]bool, level)}
+	levels.markObject(t, 0)
+	return levels
+}
+
+func (levels *indexLevels) markObject(t queriedFieldTable, level int) {
+	levels.colons[level] = true
+	for _, entry := range t {
+		levels.markValue(entry, level)
+	}
+}
+
+func (levels *indexLevels) markValue(entry *queriedFieldEntry, level int) {
+	// commas are also used to find the ends of objects and arrays queried as atomic values
+	levels.commas[level] = true
+	if entry.isAtomic() {
+		return
+	}
+	if entry.isObject() {
+		levels.markObject(entry.children, level+1)
+	} else {
+		levels.commas[level+1] = true
+		levels.markValue(entry.element, level+1)
+	}
+}
+
+/*
+buildLeveledBitmaps builds leveled colon bitmaps and leveled comma bitmaps.
+
+Both of objects and arrays are counted as nesting, so level i of the bitmaps has colons and commas
+which are nested at most i+1 times.
+When levels is not nil, the bitmaps of unused levels are not built and left nil.
+See section 4.2.4.
+*/
+func buildLeveledBitmaps(bitmaps *structualCharacterBitmaps, stringMaskBitmap []uint64, level int, levels *indexLevels) ([][]uint64, [][]uint64, error) {
+	colonBitmaps := make([][]uint64, level)
+	commaBitmaps := make([][]uint64, level)
+	if err := fillLeveledBitmaps(bitmaps, stringMaskBitmap, levels, colonBitmaps, commaBitmaps, newMaskStack()); err != nil {
+		return nil, nil, err
+	}
+	return colonBitmaps, commaBitmaps, nil
+}
+
+/*
+fillLeveledBitmaps fills the leveled bitmaps reusing their underlying arrays and stack.
+*/
+func fillLeveledBitmaps(bitmaps *structualCharacterBitmaps, stringMaskBitmap []uint64, levels *indexLevels, colonBitmaps, commaBitmaps [][]uint64, stack *maskStack) error {
+	level := len(colonBitmaps)
 	bitmapLen := len(stringMaskBitmap)
 	colons := bitmaps.colons
+	commas := bitmaps.commas
 	lBraces := bitmaps.lBraces
 	rBraces := bitmaps.rBraces
+	lBrackets := bitmaps.lBrackets
+	rBrackets := bitmaps.rBrackets
 
-	// make colons, lBraces, rBraces to be structual
+	// make structual characters to be structual
 	for i := 0; i < bitmapLen; i++ {
 		stringMask := ^stringMaskBitmap[i]
 		colons[i] &= stringMask
+		commas[i] &= stringMask
 		lBraces[i] &= stringMask
 		rBraces[i] &= stringMask
+		lBrackets[i] &= stringMask
+		rBrackets[i] &= stringMask
 	}
 
-	colonBitmaps := make([][]uint32, level)
 	for i := 0; i < level; i++ {
-		colonBitmaps[i] = make([]uint32, bitmapLen)
-		copy(colonBitmaps[i], colons)
+		if levels == nil || levels.colons[i] {
+			colonBitmaps[i] = resizeBitmap(colonBitmaps[i], bitmapLen)
+			copy(colonBitmaps[i], colons)
+		} else {
+			colonBitmaps[i] = nil
+		}
+		if levels == nil || levels.commas[i] {
+			commaBitmaps[i] = resizeBitmap(commaBitmaps[i], bitmapLen)
+			copy(commaBitmaps[i], commas)
+		} else {
+			commaBitmaps[i] = nil
+		}
 	}
-	stack := newMaskStack()
+	stack.sp = 0
 
 	for i := 0; i < bitmapLen; i++ {
-		mLeft := lBraces[i]
-		mRight := rBraces[i]
+		mLeft := lBraces[i] | lBrackets[i]
+		mRight := rBraces[i] | rBrackets[i]
 		for {
 			mLeftBit := extractRightmost1(mLeft)
 			mRightBit := extractRightmost1(mRight)
@@ -274,19 +472,29 @@ func buildLeveledColonBitmaps(bitmaps *structualCharacterBitmaps, stringMaskBitm
 			if mRightBit != 0 {
 				var j int
 				var err error
+				isBrace := rBraces[i]&mRightBit != 0
 				j, mLeftBit, err = stack.pop()
 				if err != nil {
-					offset := bits.LeadingZeros32(mRightBit)
-					return nil, fmt.Errorf("unexpected right curry blace is found at position %d", i*32+32-offset)
+					if isBrace {
+						return newSyntaxError(bitPosition(i, mRightBit), ErrUnexpectedClosing, "unexpected right curry blace")
+					}
+					return newSyntaxError(bitPosition(i, mRightBit), ErrUnexpectedClosing, "unexpected right bracket")
+				}
+				if isBrace != (lBraces[j]&mLeftBit != 0) {
+					return newSyntaxError(bitPosition(i, mRightBit), ErrUnexpectedClosing, "mismatched closing character")
 				}
 				if stack.sp > 0 && stack.sp <= level {
-					if i == j {
-						colonBitmaps[stack.sp-1][i] &= ^(mRightBit - mLeftBit)
-					} else {
-						colonBitmaps[stack.sp-1][j] &= mLeftBit - 1
-						colonBitmaps[stack.sp-1][i] &= ^(mRightBit - 1)
-						for k := j + 1; k < i; k++ {
-							colonBitmaps[stack.sp][k] = 0
+					for _, leveled := range [][]uint64{colonBitmaps[stack.sp-1], commaBitmaps[stack.sp-1]} {
+						if leveled == nil {
+							continue
+						} else if i == j {
+							leveled[i] &= ^(mRightBit - mLeftBit)
+						} else {
+							leveled[j] &= mLeftBit - 1
+							leveled[i] &= ^(mRightBit - 1)
+							for k := j + 1; k < i; k++ {
+								leveled[k] = 0
+							}
 						}
 					}
 				}
@@ -297,16 +505,31 @@ func buildLeveledColonBitmaps(bitmaps *structualCharacterBitmaps, stringMaskBitm
 		}
 	}
 
-	return colonBitmaps, nil
+	if stack.sp > 0 {
+		j, mLeftBit, _ := stack.pop()
+		return newSyntaxError(bitPosition(j, mLeftBit), ErrUnclosed, "unclosed left brace or bracket")
+	}
+
+	return nil
 }
 
-func generateColonPositions(index [][]uint32, start, end, level int) []int {
-	colons := make([]int, 0)
-	for i := int(math.Floor(float64(start) / 32)); i <= int(math.Floor(float64(end)/32)); i++ {
+func generateColonPositions(index [][]uint64, start, end, level int) []int {
+	return appendColonPositions(make([]int, 0), index, start, end, level)
+}
+
+/*
+appendColonPositions appends the positions of the colons in [start, end] of the level to colons.
+*/
+func appendColonPositions(colons []int, index [][]uint64, start, end, level int) []int {
+	last := int(math.Floor(float64(end) / wordSize))
+	if last >= len(index[level]) {
+		last = len(index[level]) - 1
+	}
+	for i := int(math.Floor(float64(start) / wordSize)); i <= last; i++ {
 		mColon := index[level][i]
 		for mColon != 0 {
 			mBit := extractRightmost1(mColon)
-			offset := i*32 + popcnt(mBit-1)
+			offset := bitPosition(i, mBit)
 			if offset >= start && offset <= end {
 				colons = append(colons, offset)
 			}
@@ -316,65 +539,151 @@ func generateColonPositions(index [][]uint32, start, end, level int) []int {
 	return colons
 }
 
-func buildStructualIndex(json []byte, level int) (*structualIndex, error) {
-	charactersBitmaps := buildStructualCharacterBitmaps(json)
-	quoteBitmap := buildStructualQuoteBitmap(charactersBitmaps)
-	stringMaskBitmap := buildStringMaskBitmap(quoteBitmap)
-	leveledColonBitmaps, err := buildLeveledColonBitmaps(charactersBitmaps, stringMaskBitmap, level)
+/*
+nextPosition returns the position of the first 1 in [start, end] of the bitmap, or -1 if it is not found.
+*/
+func nextPosition(bitmap []uint64, start, end int) int {
+	if start > end {
+		return -1
+	}
+	last := end / wordSize
+	if last >= len(bitmap) {
+		last = len(bitmap) - 1
+	}
+	for i := start / wordSize; i <= last; i++ {
+		m := bitmap[i]
+		if i == start/wordSize {
+			m &= ^(uint64(1)<<uint(start%wordSize) - 1)
+		}
+		if m != 0 {
+			offset := i*wordSize + bits.TrailingZeros64(m)
+			if offset > end {
+				return -1
+			}
+			return offset
+		}
+	}
+	return -1
+}
+
+func buildStructualIndex(json []byte, level int, levels *indexLevels) (*structualIndex, error) {
+	index := &structualIndex{}
+	if err := index.build(json, level, levels, &indexBuffers{}); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+/*
+build builds the structural index of json into index with the intermediate bitmaps in buffers.
+The bitmaps of the previous json are overwritten, and their underlying arrays are reused.
+*/
+func (index *structualIndex) build(json []byte, level int, levels *indexLevels, buffers *indexBuffers) error {
+	bitmapLen := (len(json)-1)/wordSize + 1
+	bitmaps := &buffers.bitmaps
+	bitmaps.resize(bitmapLen)
+	classifyStructualCharacters(json, bitmaps)
+	buffers.quotes = resizeBitmap(buffers.quotes, bitmapLen)
+	if err := fillStructualQuoteBitmap(bitmaps, buffers.quotes); err != nil {
+		return err
+	}
+	index.stringMaskBitmap = resizeBitmap(index.stringMaskBitmap, bitmapLen)
+	fillStringMaskBitmap(buffers.quotes, index.stringMaskBitmap)
+
+	if cap(index.leveledColonBitmaps) < level {
+		index.leveledColonBitmaps = make([][]uint64, level)
+		index.leveledCommaBitmaps = make([][]uint64, level)
+	}
+	index.leveledColonBitmaps = index.leveledColonBitmaps[:level]
+	index.leveledCommaBitmaps = index.leveledCommaBitmaps[:level]
+	err := fillLeveledBitmaps(bitmaps, index.stringMaskBitmap, levels, index.leveledColonBitmaps, index.leveledCommaBitmaps, &buffers.stack)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			syntaxErr.locate(json)
+		}
+		return err
+	}
 
+	index.json = json
+	index.level = level
+	return nil
+}
+
+/*
+retrieveFieldName returns the decoded name of the field whose colon is at colon.
+The returned slice refers json when the name has no escape sequence.
+*/
+func retrieveFieldName(json []byte, stringMaskBitmap []uint64, colon int, options literalOptions) ([]byte, error) {
+	startQuote, endQuote, err := findFieldName(json, stringMaskBitmap, colon)
 	if err != nil {
 		return nil, err
 	}
 
-	return &structualIndex{
-		json:                json,
-		level:               level,
-		stringMaskBitmap:    stringMaskBitmap,
-		leveledColonBitmaps: leveledColonBitmaps,
-	}, nil
+	fieldName, err := decodeFieldName(json[startQuote+1:endQuote], options)
+	if err != nil {
+		return nil, stringSyntaxError(json, startQuote+1, err)
+	}
+
+	return fieldName, nil
 }
 
-func retrieveFieldName(json []byte, stringMaskBitmap []uint32, colon int) (string, error) {
+/*
+findFieldName returns the positions of the starting and ending quotes of the field name whose colon is at colon.
+*/
+func findFieldName(json []byte, stringMaskBitmap []uint64, colon int) (int, int, error) {
 	// find ending quote
-	i := (colon - 1) / 32
-	mask := stringMaskBitmap[i] & (uint32(1)<<uint32(colon) - 1)
-	if mask == uint32(0) {
+	i := colon / wordSize
+	mask := stringMaskBitmap[i] & (uint64(1)<<uint(colon%wordSize) - 1)
+	if mask == 0 {
 		for i--; i >= 0 && stringMaskBitmap[i] == 0; i-- {
 		}
 
 		if i < 0 {
-			return "", fmt.Errorf("ending quote for colon at %d is not found", colon)
+			return -1, -1, syntaxErrorAt(json, colon, ErrFieldNameNotFound, "ending quote for colon is not found")
 		}
 
 		mask = stringMaskBitmap[i]
 	}
 
-	leadingZeros := bits.LeadingZeros32(mask)
-	endQuote := 32*i + 31 - leadingZeros
+	leadingZeros := bits.LeadingZeros64(mask)
+	endQuote := wordSize*i + wordSize - 1 - leadingZeros
 
-	leadingOnes := bits.LeadingZeros32(^(mask << uint32(leadingZeros)))
+	leadingOnes := bits.LeadingZeros64(^(mask << uint(leadingZeros)))
 
-	if leadingOnes == 32-leadingZeros {
+	if leadingOnes == wordSize-leadingZeros {
 		for i--; i >= 0; i-- {
-			l := bits.LeadingZeros32(^stringMaskBitmap[i])
+			l := bits.LeadingZeros64(^stringMaskBitmap[i])
 			leadingOnes += l
-			if l != 32 {
+			if l != wordSize {
 				break
 			}
 		}
 
 		if i < 0 {
-			return "", fmt.Errorf("starting quote for colon at %d is not found", colon)
+			return -1, -1, syntaxErrorAt(json, colon, ErrFieldNameNotFound, "starting quote for colon is not found")
 		}
 	}
 
 	startQuote := endQuote - leadingOnes
-	fieldName, err := strconv.Unquote(string(json[startQuote : endQuote+1]))
-	if err != nil {
-		return "", err
+	if json[startQuote] != '"' || json[endQuote] != '"' {
+		return -1, -1, syntaxErrorAt(json, colon, ErrFieldNameNotFound, "field name for colon is not found")
 	}
 
-	return fieldName, nil
+	return startQuote, endQuote, nil
+}
+
+/*
+decodeFieldName decodes the body of the field name (without quotes).
+The body is returned as is when it has neither escape sequences nor control characters.
+*/
+func decodeFieldName(body []byte, options literalOptions) ([]byte, error) {
+	for _, c := range body {
+		if c == '\\' || c < 0x20 {
+			return appendUnescaped(make([]byte, 0, len(body)), body, options)
+		}
+	}
+	return body, nil
 }
 
 type queriedFieldTable map[string]*queriedFieldEntry
@@ -423,11 +732,46 @@ func findStructualDot(queriedField string) int {
 	return -1
 }
 
+/*
+scanQueriedFieldName returns the length of the field name at the head of queriedField.
+The name ends at an unescaped dot or bracket.
+*/
+func scanQueriedFieldName(queriedField string) int {
+	for i := 0; i < len(queriedField); i++ {
+		switch queriedField[i] {
+		case '.', '[', ']':
+			return i
+		case '\\':
+			if i+1 == len(queriedField) || queriedField[i+1] == '\n' {
+				return i
+			}
+			i++
+		}
+	}
+	return len(queriedField)
+}
+
+/*
+unescapeQueriedFieldName removes the backslashes which escape the following characters.
+*/
+func unescapeQueriedFieldName(name string) string {
+	if strings.IndexByte(name, '\\') < 0 {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) && name[i+1] != '\n' {
+			i++
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
 func buildQueriedFieldTableFromSingleField(t queriedFieldTable, queriedField, fullField string, nextID int, level int) (int, error) {
 	maxLevel := level
-	r := regexp.MustCompile(`\\(.)`)
 	if dot := findStructualDot(queriedField); dot >= 0 {
-		parent := r.ReplaceAllString(queriedField[0:dot], `$1`)
+		parent := unescapeQueriedFieldName(queriedField[0:dot])
 		child := queriedField[dot+1:]
 
 		if _, ok := t[parent]; !ok {
@@ -443,7 +787,7 @@ func buildQueriedFieldTableFromSingleField(t queriedFieldTable, queriedField, fu
 			maxLevel = l
 		}
 	} else {
-		queriedField = r.ReplaceAllString(queriedField, `$1`)
+		queriedField = unescapeQueriedFieldName(queriedField)
 		if _, ok := t[queriedField]; ok {
 			return -1, fmt.Errorf("duplicated field %q", fullField)
 		}
@@ -454,15 +798,13 @@ func buildQueriedFieldTableFromSingleField(t queriedFieldTable, queriedField, fu
 
 func parseQueriedField(t queriedFieldTable, queriedField, fullField string, nextID int, level int) (int, error) {
 	// Extract field
-	namePattern := regexp.MustCompile(`^([^][.\\]|\\.)+`)
-	loc := namePattern.FindStringIndex(queriedField)
-	if loc == nil {
+	n := scanQueriedFieldName(queriedField)
+	if n == 0 {
 		return -1, errors.New("expected field name, but not found")
 	}
 
-	excapePattern := regexp.MustCompile(`\\(.)`)
-	name := excapePattern.ReplaceAllString(queriedField[loc[0]:loc[1]], `$1`)
-	rest := queriedField[loc[1]:]
+	name := unescapeQueriedFieldName(queriedField[:n])
+	rest := queriedField[n:]
 
 	if rest == "" {
 		if _, ok := t[name]; ok {
@@ -520,6 +862,9 @@ func parseQueriedArray(array *queriedFieldEntry, queriedField, fullField string,
 }
 
 func buildQueriedFieldTable(queriedFields []string) (queriedFieldTable, int, error) {
+	if len(queriedFields) == 0 {
+		return nil, -1, errors.New("no queried fields are given")
+	}
 	t := make(queriedFieldTable)
 	level := 0
 
@@ -554,14 +899,49 @@ const (
 	JSONString
 	// JSONEndOfRecord represents end of record
 	JSONEndOfRecord
+	// JSONObject represents object in JSON which is queried as atomic value
+	JSONObject
+	// JSONArray represents array in JSON which is queried as atomic value
+	JSONArray
 )
 
-// KeyValue represents found key-value in JSON
+func (t JSONType) String() string {
+	switch t {
+	case JSONNull:
+		return "null"
+	case JSONBool:
+		return "bool"
+	case JSONNumber:
+		return "number"
+	case JSONString:
+		return "string"
+	case JSONEndOfRecord:
+		return "end of record"
+	case JSONObject:
+		return "object"
+	case JSONArray:
+		return "array"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+KeyValue represents found key-value in JSON.
+When an atomic queried field has an object or an array, Value is json.RawMessage of the whole value.
+*/
 type KeyValue struct {
 	FieldID  int
 	Value    interface{}
 	RawValue string
 	Type     JSONType
+	// Start and End are the byte offsets of RawValue in the record
+	Start int
+	End   int
+	// KeyOffset is the byte offset of the starting quote of the nearest field name on the path to the value
+	KeyOffset int
+	// Indices are the indices of the array elements on the path to the value, which is nil out of arrays
+	Indices []int
 }
 
 // IsEndOfRecord check end of record
@@ -569,113 +949,458 @@ func (kv *KeyValue) IsEndOfRecord() bool {
 	return kv.Type == JSONEndOfRecord
 }
 
+/*
+LoneSurrogatePolicy represents how to decode \u escapes of UTF-16 surrogates which are not paired
+*/
+type LoneSurrogatePolicy int
+
+const (
+	// LoneSurrogateReplace replaces lone surrogates with U+FFFD like encoding/json
+	LoneSurrogateReplace LoneSurrogatePolicy = iota
+	// LoneSurrogateError makes lone surrogates to be error
+	LoneSurrogateError
+)
+
+type literalOptions struct {
+	loneSurrogate LoneSurrogatePolicy
+	numberMode    NumberMode
+}
+
+func decodeHex4(s []byte) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range s[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c = c - '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+/*
+unescapeString decodes the body of JSON string (without quotes).
+*/
+func unescapeString(body []byte, options literalOptions) (string, error) {
+	buf, err := appendUnescaped(make([]byte, 0, len(body)), body, options)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+/*
+appendUnescaped decodes the body of JSON string (without quotes) and appends it to buf.
+*/
+func appendUnescaped(buf []byte, body []byte, options literalOptions) ([]byte, error) {
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if ch < 0x20 {
+			return nil, &stringError{pos: i, msg: fmt.Sprintf("invalid control character %q", ch)}
+		}
+		if ch != '\\' {
+			buf = append(buf, ch)
+			continue
+		}
+
+		if i+1 >= len(body) {
+			return nil, &stringError{pos: i, msg: "unterminated escape sequence"}
+		}
+		next := body[i+1]
+		i++
+		switch next {
+		case '"', '\\', '/':
+			buf = append(buf, next)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, ok := decodeHex4(body[i+1:])
+			if !ok {
+				return nil, &stringError{pos: i - 1, msg: "invalid unicode escape"}
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				var r2 rune = utf8.RuneError
+				if i+2 < len(body) && body[i+1] == '\\' && body[i+2] == 'u' {
+					if low, ok := decodeHex4(body[i+3:]); ok {
+						r2 = utf16.DecodeRune(r, low)
+					}
+				}
+				if r2 != utf8.RuneError {
+					i += 6
+					r = r2
+				} else if options.loneSurrogate == LoneSurrogateError {
+					return nil, &stringError{pos: i - 5, msg: fmt.Sprintf("lone surrogate \\u%04X", r)}
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			buf = append(buf, string(r)...)
+		default:
+			return nil, &stringError{pos: i - 1, msg: fmt.Sprintf("invalid escape character %q", next)}
+		}
+	}
+	return buf, nil
+}
+
+/*
+stringSyntaxError converts the error of decoding the string body starting from bodyStart to SyntaxError.
+*/
+func stringSyntaxError(json []byte, bodyStart int, err error) error {
+	var strErr *stringError
+	if errors.As(err, &strErr) {
+		return syntaxErrorAt(json, bodyStart+strErr.pos, ErrInvalidString, strErr.msg)
+	}
+	return err
+}
+
+/*
+scanLiteral returns the length of the literal (true, false, null, number or string) at the head of json, or 0 if no literal is found.
+escaped is true when the string has backslashes or control characters, which must be checked and decoded by unescapeString.
+*/
+func scanLiteral(json []byte) (n int, escaped bool) {
+	switch json[0] {
+	case 't':
+		return scanKeyword(json, "true"), false
+	case 'f':
+		return scanKeyword(json, "false"), false
+	case 'n':
+		return scanKeyword(json, "null"), false
+	case '"':
+		return scanString(json)
+	default:
+		return scanNumber(json), false
+	}
+}
+
+func scanKeyword(json []byte, keyword string) int {
+	if len(json) < len(keyword) || string(json[:len(keyword)]) != keyword {
+		return 0
+	}
+	return len(keyword)
+}
+
+/*
+scanString returns the length of the string at the head of json, or 0 if the string is not terminated in the line.
+*/
+func scanString(json []byte) (int, bool) {
+	escaped := false
+	for i := 1; i < len(json); i++ {
+		switch c := json[i]; {
+		case c == '"':
+			return i + 1, escaped
+		case c == '\\':
+			if i+1 == len(json) || json[i+1] == '\n' {
+				return 0, false
+			}
+			escaped = true
+			i++
+		case c == '\n':
+			return 0, false
+		case c < 0x20:
+			escaped = true
+		}
+	}
+	return 0, false
+}
+
+/*
+scanNumber returns the length of the longest number at the head of json.
+Fraction and exponent parts are scanned only when they have digits.
+*/
+func scanNumber(json []byte) int {
+	i := 0
+	if i < len(json) && json[i] == '-' {
+		i++
+	}
+	if i == len(json) {
+		return 0
+	} else if json[i] == '0' {
+		i++
+	} else if '1' <= json[i] && json[i] <= '9' {
+		i = scanDigits(json, i+1)
+	} else {
+		return 0
+	}
+
+	if i+1 < len(json) && json[i] == '.' && isDigit(json[i+1]) {
+		i = scanDigits(json, i+2)
+	}
+	if i < len(json) && (json[i] == 'e' || json[i] == 'E') {
+		j := i + 1
+		if j < len(json) && (json[j] == '+' || json[j] == '-') {
+			j++
+		}
+		if j < len(json) && isDigit(json[j]) {
+			i = scanDigits(json, j+1)
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func scanDigits(json []byte, i int) int {
+	for i < len(json) && isDigit(json[i]) {
+		i++
+	}
+	return i
+}
+
 var errUnexpectedObject = errors.New("unexpected object")
 var errUnexpectedArray = errors.New("unexpected array")
 
-func parseLiteral(json []byte, colon int) (interface{}, string, JSONType, error) {
-	i := colon + 1
+func parseLiteral(json []byte, colon int, options literalOptions) (interface{}, string, JSONType, error) {
+	l, err := findLiteral(json, colon)
+	if err != nil {
+		return nil, "", JSONUnknown, err
+	}
+	v, err := l.decode(json, options)
+	if err != nil {
+		return nil, "", JSONUnknown, err
+	}
+	return v, string(json[l.start:l.end]), l.t, nil
+}
+
+/*
+literal is the range of a literal value in the record.
+*/
+type literal struct {
+	start int
+	end   int
+	t     JSONType
+	// whether the string must be decoded by unescapeString
+	escaped bool
+}
+
+/*
+findLiteral finds the literal after the delimiter (colon, comma or left bracket) without decoding it.
+*/
+func findLiteral(json []byte, delimiter int) (literal, error) {
 	size := len(json)
 	// skip blanks
-	for ; i < size; i++ {
-		if !(json[i] == ' ' || json[i] == '\t' || json[i] == '\n') {
-			break
-		}
-	}
+	i := skipBlanks(json, delimiter+1)
 
 	if i == size {
-		return nil, "", JSONUnknown, errors.New("value is not found")
+		return literal{}, syntaxErrorAt(json, i, ErrValueNotFound, "value is not found")
 	}
 
 	if json[i] == '{' {
-		return nil, "", JSONUnknown, errUnexpectedObject
+		return literal{}, errUnexpectedObject
 	} else if json[i] == '[' {
-		return nil, "", JSONUnknown, errUnexpectedArray
+		return literal{}, errUnexpectedArray
+	}
+
+	// Now scan literal
+	n, escaped := scanLiteral(json[i:size])
+	if n == 0 {
+		return literal{}, syntaxErrorAt(json, i, ErrInvalidValue, "invalid value")
 	}
 
-	// Now parse literal
-	r := regexp.MustCompile(`\A(true|false|null|-?(0|[0-9]+)(\.[0-9]+)?|"([^\\\n"]|\\[\\"/bfnrt])*")`)
-	literal := r.Find(json[i:size])
-	if literal == nil {
-		return nil, "", JSONUnknown, fmt.Errorf("value is not found at %d", i)
+	// literal must be followed by the end of value
+	if j := skipBlanks(json, i+n); j < size && json[j] != ',' && json[j] != '}' && json[j] != ']' {
+		return literal{}, syntaxErrorAt(json, j, ErrInvalidValue, "unexpected character after value")
 	}
 
-	var t JSONType
-	var v interface{}
-	switch literal[0] {
+	l := literal{start: i, end: i + n, escaped: escaped}
+	switch json[i] {
 	case 't', 'f':
-		t = JSONBool
-		v = literal[0] == 't'
+		l.t = JSONBool
 	case 'n':
-		t = JSONNull
-		v = nil
+		l.t = JSONNull
 	case '"':
-		t = JSONString
-		n := 0
-		buf := make([]byte, len(literal)-2)
-		body := literal[1 : len(literal)-1]
-		for i := 0; i < len(body); i++ {
-			ch := body[i]
-			if ch == '\\' {
-				next := body[i+1]
-				switch next {
-				case '"':
-					buf[n] = '"'
-				case '\\':
-					buf[n] = '\\'
-				case 'b':
-					buf[n] = '\b'
-				case 'f':
-					buf[n] = '\f'
-				case 'n':
-					buf[n] = '\n'
-				case 'r':
-					buf[n] = '\r'
-				case 't':
-					buf[n] = '\t'
-				default:
-					buf[n] = next
-				}
-				i++
-			} else {
-				buf[n] = body[i]
-			}
-			n++
-		}
-		buf = buf[0:n]
-		v = string(buf)
+		l.t = JSONString
 	default:
-		t = JSONNumber
-		var err error
-		v, err = strconv.ParseFloat(string(literal), 64)
+		l.t = JSONNumber
+	}
+	return l, nil
+}
+
+/*
+decode decodes the literal in json.
+*/
+func (l literal) decode(json []byte, options literalOptions) (interface{}, error) {
+	switch l.t {
+	case JSONBool:
+		return json[l.start] == 't', nil
+	case JSONString:
+		body := json[l.start+1 : l.end-1]
+		if !l.escaped {
+			return string(body), nil
+		}
+		v, err := unescapeString(body, options)
 		if err != nil {
-			panic(err)
+			return nil, stringSyntaxError(json, l.start+1, err)
 		}
+		return v, nil
+	case JSONNumber:
+		return decodeNumber(json, l.start, l.end, options.numberMode)
+	case JSONObject, JSONArray:
+		return copyRawMessage(json[l.start:l.end]), nil
+	default:
+		return nil, nil
 	}
-
-	return v, string(literal), t, nil
 }
 
 // Parser is stream provider for specified queried fields
 type Parser struct {
 	queriedFieldTable queriedFieldTable
 	level             int
+	patternTrees      map[*queriedFieldEntry]*patternTree
+	literalOptions    literalOptions
+	indexLevels       *indexLevels
+	indexWorkers      int
+	// for early termination
+	slots map[*queriedFieldEntry]int
+	// ParserStates reused by Decoder
+	states sync.Pool
+}
+
+// ParserOption is optional setting of Parser
+type ParserOption func(p *Parser)
+
+// WithLoneSurrogatePolicy sets how to decode lone surrogates in string values (default: LoneSurrogateReplace)
+func WithLoneSurrogatePolicy(policy LoneSurrogatePolicy) ParserOption {
+	return func(p *Parser) {
+		p.literalOptions.loneSurrogate = policy
+	}
+}
+
+/*
+WithPrunedIndex makes the Parser build only the levels of the structural index which are used by the queried fields.
+It saves time and memory when the queried fields are sparse in deep records.
+*/
+func WithPrunedIndex() ParserOption {
+	return func(p *Parser) {
+		p.indexLevels = newIndexLevels(p.queriedFieldTable, p.level)
+	}
+}
+
+/*
+WithParallelIndex makes the Parser build the structural index of large records (1 MiB or more) with the given number of goroutines.
+If workers is not positive, GOMAXPROCS is used.
+*/
+func WithParallelIndex(workers int) ParserOption {
+	return func(p *Parser) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		p.indexWorkers = workers
+	}
 }
 
 // NewParser creates and initializes a new Parser for given queried fields
-func NewParser(queriedFields []string) (*Parser, error) {
+func NewParser(queriedFields []string, options ...ParserOption) (*Parser, error) {
 	t, level, err := buildQueriedFieldTable(queriedFields)
 	if err != nil {
 		return nil, err
 	}
-	return &Parser{queriedFieldTable: t, level: level}, nil
+	p := &Parser{queriedFieldTable: t, level: level, slots: make(map[*queriedFieldEntry]int)}
+	p.assignSlots(t)
+	for _, option := range options {
+		option(p)
+	}
+	p.states.New = func() interface{} {
+		return p.NewParserState()
+	}
+	return p, nil
+}
+
+/*
+assignSlots assigns slots for early termination to the entries which are resolved at once.
+Atomic fields out of arrays are resolved when the values are found,
+and arrays out of arrays are resolved when all elements are scanned.
+*/
+func (p *Parser) assignSlots(t queriedFieldTable) {
+	for _, entry := range t {
+		if entry.isObject() {
+			p.assignSlots(entry.children)
+		} else {
+			p.slots[entry] = len(p.slots)
+		}
+	}
+}
+
+/*
+Train builds pattern trees of queried fields from the sample records.
+After training, ParserState speculates the positions of queried fields from the pattern trees
+and falls back to the full scan when the speculation fails.
+
+A pattern is used only for objects with the same number of colons as the training objects of the pattern,
+so objects with additional duplicated fields fall back to the full scan.
+Only the colons at the speculated positions are scanned, so a duplicated field which replaces
+an unqueried field of the pattern is not returned, while it is returned without training.
+
+Train must not be called while p is used by other goroutines.
+See section 5.
+*/
+func (p *Parser) Train(records [][]byte) error {
+	if p.patternTrees == nil {
+		p.patternTrees = make(map[*queriedFieldEntry]*patternTree)
+	}
+
+	for _, record := range records {
+		ps, err := p.StartParse(record)
+		if err != nil {
+			return err
+		}
+		ps.training = true
+		for {
+			kv, err := ps.Next()
+			if err != nil {
+				return err
+			}
+			if kv.IsEndOfRecord() {
+				break
+			}
+		}
+	}
+
+	return nil
 }
 
-// ParserState is state of parsing the json
+/*
+ParserState is state of parsing the json.
+It can be reused for other records by Reset, which reuses the buffers of the previous record.
+*/
 type ParserState struct {
-	p     *Parser
-	index *structualIndex
-	stack []parserStateStack
-	sp    int
+	p        *Parser
+	index    *structualIndex
+	buffers  indexBuffers
+	stack    []parserStateStack
+	sp       int
+	training bool
+	// for early termination
+	resolved []bool
+	pending  int
+	// buffers for speculation
+	steps []patternStep
+	// the atomic value found by nextField or nextElement
+	entry    *queriedFieldEntry
+	value    literal
+	keyColon int
+	// returned at the end of every record
+	endOfRecord KeyValue
+	rawKV       RawKeyValue
 }
 
 type parserStateStack struct {
@@ -684,84 +1409,456 @@ type parserStateStack struct {
 	level        int
 	colons       []int
 	currentColon int
+	generated    bool
 	table        queriedFieldTable
+	owner        *queriedFieldEntry
+	// the colon of the nearest field on the path to the flame, or -1 for the root
+	keyColon int
+	// for speculation
+	names      []string
+	speculated bool
+	found      []patternStep
+	// for array flame
+	isArray bool
+	array   *queriedFieldEntry
+	cursor  int
+	element int
+	// buffers of colons
+	scannedColons    []int
+	speculatedColons []int
 }
 
 // StartParse returns a new ParserState
 func (p *Parser) StartParse(json []byte) (*ParserState, error) {
-	index, err := buildStructualIndex(json, p.level)
-	if err != nil {
+	ps := p.NewParserState()
+	if err := ps.Reset(json); err != nil {
 		return nil, err
 	}
-	stack := make([]parserStateStack, p.level)
-	stack[0].start = 0
-	stack[0].end = len(json)
-	stack[0].level = 0
-	stack[0].table = p.queriedFieldTable
-	return &ParserState{p: p, index: index, stack: stack, sp: 0}, nil
+	return ps, nil
+}
+
+/*
+NewParserState returns a new ParserState which has no record.
+Records are set by Reset, so the ParserState can be reused without allocations for each record.
+*/
+func (p *Parser) NewParserState() *ParserState {
+	return &ParserState{
+		p:           p,
+		index:       &structualIndex{},
+		stack:       make([]parserStateStack, p.level),
+		sp:          -1,
+		resolved:    make([]bool, len(p.slots)),
+		endOfRecord: KeyValue{FieldID: -1, Type: JSONEndOfRecord, Value: nil, RawValue: ""},
+	}
+}
+
+/*
+Reset starts parsing json with ps, discarding the rest of the previous record.
+The buffers for the previous record are reused.
+After an error, ps has no record until the next successful Reset.
+
+Reset itself does not allocate in steady state, but Next allocates a *KeyValue for each value.
+Use NextRaw to parse records without allocations.
+*/
+func (ps *ParserState) Reset(json []byte) error {
+	p := ps.p
+	ps.sp = -1
+	if p.indexWorkers > 1 && len(json) >= parallelIndexMinSize {
+		index, err := buildStructualIndexParallel(json, p.level, p.indexLevels, p.indexWorkers)
+		if err != nil {
+			return err
+		}
+		ps.index = index
+	} else if err := ps.index.build(json, p.level, p.indexLevels, &ps.buffers); err != nil {
+		return err
+	}
+
+	root := &ps.stack[0]
+	root.start = 0
+	root.end = skipBlanksBackward(json, len(json)-1)
+	root.level = 0
+	root.keyColon = -1
+	root.generated = false
+	root.table = p.queriedFieldTable
+	ps.sp = 0
+	ps.training = false
+	for i := range ps.resolved {
+		ps.resolved[i] = false
+	}
+	ps.pending = len(ps.resolved)
+	return nil
+}
+
+func skipBlanks(json []byte, i int) int {
+	size := len(json)
+	for ; i < size; i++ {
+		if !(json[i] == ' ' || json[i] == '\t' || json[i] == '\n' || json[i] == '\r') {
+			break
+		}
+	}
+	return i
+}
+
+func skipBlanksBackward(json []byte, i int) int {
+	for ; i >= 0; i-- {
+		if !(json[i] == ' ' || json[i] == '\t' || json[i] == '\n' || json[i] == '\r') {
+			break
+		}
+	}
+	return i
+}
+
+/*
+valueEnd returns the position of the comma or the closing character just after the value starting from start.
+*/
+func (ps *ParserState) valueEnd(flame *parserStateStack, start int) int {
+	comma := nextPosition(ps.index.leveledCommaBitmaps[flame.level], start, flame.end-1)
+	if comma < 0 {
+		return flame.end
+	}
+	return comma
+}
+
+func (ps *ParserState) pushObjectFlame(lBrace, rBrace, level, keyColon int, owner *queriedFieldEntry) {
+	ps.sp++
+	newFlame := &ps.stack[ps.sp]
+	newFlame.start = lBrace
+	newFlame.end = rBrace
+	newFlame.level = level
+	newFlame.keyColon = keyColon
+	newFlame.generated = false
+	newFlame.table = owner.children
+	newFlame.owner = owner
+	newFlame.isArray = false
+	newFlame.array = nil
+}
+
+func (ps *ParserState) pushArrayFlame(lBracket, rBracket, level, keyColon int, array *queriedFieldEntry) {
+	ps.sp++
+	newFlame := &ps.stack[ps.sp]
+	newFlame.start = lBracket
+	newFlame.end = rBracket
+	newFlame.level = level
+	newFlame.keyColon = keyColon
+	newFlame.generated = false
+	newFlame.table = nil
+	newFlame.owner = nil
+	newFlame.isArray = true
+	newFlame.array = array
+	newFlame.cursor = lBracket
+	newFlame.element = -1
+}
+
+/*
+speculate narrows down the colons of the object flame to the positions predicted by the pattern tree.
+The predicted positions are verified by the field names and the number of colons,
+and flame is unchanged when no pattern is verified.
+*/
+func (ps *ParserState) speculate(flame *parserStateStack) {
+	tree, ok := ps.p.patternTrees[flame.owner]
+	if !ok {
+		return
+	}
+
+	verify := func(step patternStep) bool {
+		if step.ordinal >= len(flame.colons) {
+			return false
+		}
+		name, err := retrieveFieldName(ps.index.json, ps.index.stringMaskBitmap, flame.colons[step.ordinal], ps.p.literalOptions)
+		return err == nil && string(name) == step.name
+	}
+	steps, ok := tree.speculate(ps.steps[:0], len(flame.colons), verify)
+	if !ok {
+		return
+	}
+	ps.steps = steps[:0]
+
+	colons := flame.speculatedColons[:0]
+	names := flame.names[:0]
+	for _, step := range steps {
+		colons = append(colons, flame.colons[step.ordinal])
+		names = append(names, step.name)
+	}
+	flame.speculatedColons = colons
+	flame.colons = colons
+	flame.names = names
+	flame.speculated = true
+}
+
+/*
+learn adds the queried fields found in the object flame to the pattern tree.
+*/
+func (p *Parser) learn(flame *parserStateStack) {
+	tree, ok := p.patternTrees[flame.owner]
+	if !ok {
+		tree = &patternTree{}
+		p.patternTrees[flame.owner] = tree
+	}
+	tree.insert(flame.found, len(flame.found) == len(flame.table), len(flame.colons))
 }
 
-// Next returns next key/value
+/*
+resolve marks entry as resolved.
+Only the first occurrence of duplicated fields is counted.
+*/
+func (ps *ParserState) resolve(entry *queriedFieldEntry) {
+	slot, ok := ps.p.slots[entry]
+	if !ok || ps.resolved[slot] {
+		return
+	}
+	ps.resolved[slot] = true
+	ps.pending--
+}
+
+/*
+Next returns next key/value.
+The end of record is returned as soon as all queried fields are found,
+so duplicated fields after that are not returned.
+A new *KeyValue (and its decoded value) is allocated for each value.
+*/
 func (ps *ParserState) Next() (*KeyValue, error) {
+	found, err := ps.advance()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return &ps.endOfRecord, nil
+	}
+
+	json := ps.index.json
+	v, err := ps.value.decode(json, ps.p.literalOptions)
+	if err != nil {
+		return nil, err
+	}
+	keyOffset, _, err := findFieldName(json, ps.index.stringMaskBitmap, ps.keyColon)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyValue{
+		FieldID:   ps.entry.id,
+		Type:      ps.value.t,
+		Value:     v,
+		RawValue:  string(json[ps.value.start:ps.value.end]),
+		Start:     ps.value.start,
+		End:       ps.value.end,
+		KeyOffset: keyOffset,
+		Indices:   ps.indices(),
+	}, nil
+}
+
+/*
+indices returns the indices of the elements of the array flames on the stack.
+*/
+func (ps *ParserState) indices() []int {
+	n := 0
+	for i := 0; i <= ps.sp; i++ {
+		if ps.stack[i].isArray {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	indices := make([]int, 0, n)
+	for i := 0; i <= ps.sp; i++ {
+		if flame := &ps.stack[i]; flame.isArray {
+			indices = append(indices, flame.element)
+		}
+	}
+	return indices
+}
+
+/*
+advance advances ps to the next atomic value of queried fields.
+It returns false at the end of record.
+*/
+func (ps *ParserState) advance() (bool, error) {
 	if ps.sp < 0 {
-		return nil, errors.New("already finished")
+		return false, ErrAlreadyFinished
+	}
+
+	for ps.sp >= 0 && (ps.pending > 0 || ps.training) {
+		var found bool
+		var err error
+		if ps.stack[ps.sp].isArray {
+			found, err = ps.nextElement()
+		} else {
+			found, err = ps.nextField()
+		}
+		if err != nil || found {
+			return found, err
+		}
 	}
 
+	ps.sp = -1
+	return false, nil
+}
+
+/*
+nextField advances the object flame on the top of the stack by one colon.
+It returns false when no value is found at the colon.
+*/
+func (ps *ParserState) nextField() (bool, error) {
 	flame := &ps.stack[ps.sp]
-	if flame.colons == nil {
-		flame.colons = generateColonPositions(ps.index.leveledColonBitmaps, flame.start, flame.end, flame.level)
+	if !flame.generated {
+		flame.scannedColons = appendColonPositions(flame.scannedColons[:0], ps.index.leveledColonBitmaps, flame.start, flame.end, flame.level)
+		flame.colons = flame.scannedColons
+		flame.generated = true
 		flame.currentColon = 0
+		flame.speculated = false
+		flame.found = flame.found[:0]
+		if !ps.training {
+			ps.speculate(flame)
+		}
 	} else {
 		flame.currentColon++
 	}
 
 	if flame.currentColon >= len(flame.colons) {
-		flame.colons = nil
-		ps.sp--
-		if ps.sp < 0 {
-			return &KeyValue{FieldID: -1, Type: JSONEndOfRecord, Value: nil, RawValue: ""}, nil
+		if ps.training {
+			ps.p.learn(flame)
 		}
-		return ps.Next()
+		flame.generated = false
+		ps.sp--
+		return false, nil
 	}
 
 	colon := flame.colons[flame.currentColon]
-	name, err := retrieveFieldName(ps.index.json, ps.index.stringMaskBitmap, colon)
+	var entry *queriedFieldEntry
+	var ok bool
+	if flame.speculated {
+		entry, ok = flame.table[flame.names[flame.currentColon]]
+	} else {
+		name, err := retrieveFieldName(ps.index.json, ps.index.stringMaskBitmap, colon, ps.p.literalOptions)
+		if err != nil {
+			return false, err
+		}
+		entry, ok = flame.table[string(name)]
+		if ok && ps.training {
+			flame.found = append(flame.found, patternStep{ordinal: flame.currentColon, name: string(name)})
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+
+	if entry.isAtomic() {
+		// field is atomic value
+		return ps.findAtomic(entry, flame, colon)
+	}
+
+	if ps.sp+1 >= len(ps.stack) {
+		return false, nil
+	}
+
+	return false, ps.pushCompound(entry, colon+1, ps.valueEnd(flame, colon+1), flame.level, colon)
+}
+
+/*
+nextElement advances the array flame on the top of the stack by one element.
+It returns false when no value is found in the element.
+*/
+func (ps *ParserState) nextElement() (bool, error) {
+	json := ps.index.json
+	flame := &ps.stack[ps.sp]
+	if flame.cursor >= flame.end {
+		ps.resolve(flame.array)
+		ps.sp--
+		return false, nil
+	}
+
+	delimiter := flame.cursor
+	start := delimiter + 1
+	elementEnd := ps.valueEnd(flame, start)
+	flame.cursor = elementEnd
+	flame.element++
+
+	if skipBlanks(json, start) >= elementEnd {
+		// empty array
+		if delimiter == flame.start && elementEnd == flame.end {
+			return false, nil
+		}
+		return false, syntaxErrorAt(json, start, ErrValueNotFound, "array element is not found")
+	}
+
+	entry := flame.array.element
+	if entry.isAtomic() {
+		return ps.findAtomic(entry, flame, delimiter)
+	}
+
+	if ps.sp+1 >= len(ps.stack) {
+		return false, nil
+	}
+
+	return false, ps.pushCompound(entry, start, elementEnd, flame.level, flame.keyColon)
+}
+
+/*
+findAtomic finds the value after the delimiter (colon, comma or left bracket) in flame as the value of entry.
+Objects and arrays are found as raw values, whose ends are found with the leveled comma bitmap.
+*/
+func (ps *ParserState) findAtomic(entry *queriedFieldEntry, flame *parserStateStack, delimiter int) (bool, error) {
+	l, err := findLiteral(ps.index.json, delimiter)
+	if errors.Is(err, errUnexpectedObject) || errors.Is(err, errUnexpectedArray) {
+		l, err = ps.findCompound(flame, delimiter)
+	}
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	ps.resolve(entry)
+	ps.entry = entry
+	ps.value = l
+	ps.keyColon = delimiter
+	if flame.isArray {
+		ps.keyColon = flame.keyColon
+	}
+	return true, nil
+}
 
-	if entry, ok := flame.table[name]; ok {
-		if entry.isAtomic() {
-			// field is atomic value
-			// parse value
-			v, rv, t, err := parseLiteral(ps.index.json, colon)
-			if errors.Is(err, errUnexpectedObject) || errors.Is(err, errUnexpectedArray) {
-				// skip
-			} else if err != nil {
-				return nil, err
-			} else {
-				return &KeyValue{FieldID: entry.id, Type: t, Value: v, RawValue: rv}, nil
-			}
-		} else if flame.level+1 < ps.p.level {
-			// field is object value
-			var innerEnd int
-			if flame.currentColon < len(flame.colons)-1 {
-				innerEnd = flame.colons[flame.currentColon+1] - 1
-			} else {
-				innerEnd = flame.end - 1
-			}
-			// push new flame
-			ps.sp++
-			newFlame := &ps.stack[ps.sp]
-			newFlame.start = colon + 1
-			newFlame.end = innerEnd
-			newFlame.level = flame.level + 1
-			newFlame.colons = nil
-			newFlame.table = entry.children
+/*
+findCompound finds the object or array after the delimiter in flame.
+*/
+func (ps *ParserState) findCompound(flame *parserStateStack, delimiter int) (literal, error) {
+	json := ps.index.json
+	i := skipBlanks(json, delimiter+1)
+	closing := skipBlanksBackward(json, ps.valueEnd(flame, i)-1)
 
-			return ps.Next()
+	if json[i] == '{' {
+		if json[closing] != '}' {
+			return literal{}, syntaxErrorAt(json, closing, ErrInvalidValue, "right curry blace for left curry blace at %d is not found", i)
 		}
+		return literal{start: i, end: closing + 1, t: JSONObject}, nil
+	}
+	if json[closing] != ']' {
+		return literal{}, syntaxErrorAt(json, closing, ErrInvalidValue, "right bracket for left bracket at %d is not found", i)
+	}
+	return literal{start: i, end: closing + 1, t: JSONArray}, nil
+}
+
+/*
+pushCompound pushes a new flame for the object or array value of entry found in [start, end).
+keyColon is the colon of the nearest field on the path to the value.
+Values whose type does not match with entry are skipped.
+*/
+func (ps *ParserState) pushCompound(entry *queriedFieldEntry, start, end, level, keyColon int) error {
+	json := ps.index.json
+	i := skipBlanks(json, start)
+	if i >= end {
+		return syntaxErrorAt(json, start, ErrValueNotFound, "value is not found")
 	}
+	closing := skipBlanksBackward(json, end-1)
 
-	return ps.Next()
+	if entry.isObject() && json[i] == '{' {
+		if json[closing] != '}' {
+			return syntaxErrorAt(json, closing, ErrInvalidValue, "right curry blace for left curry blace at %d is not found", i)
+		}
+		ps.pushObjectFlame(i, closing, level+1, keyColon, entry)
+	} else if entry.isArray() && json[i] == '[' {
+		if json[closing] != ']' {
+			return syntaxErrorAt(json, closing, ErrInvalidValue, "right bracket for left bracket at %d is not found", i)
+		}
+		ps.pushArrayFlame(i, closing, level+1, keyColon, entry)
+	}
+	return nil
 }
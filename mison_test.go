@@ -1,14 +1,17 @@
 package mison
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func bitsToUint32(slice ...string) []uint32 {
-	ret := make([]uint32, len(slice))
+func bitsToUint64(slice ...string) []uint64 {
+	ret := make([]uint64, len(slice))
 	for i, bits := range slice {
 		ret[i] = 0
 		n := len(bits)
@@ -23,14 +26,14 @@ func bitsToUint32(slice ...string) []uint32 {
 	return ret
 }
 
-func uint32ToBits(x uint32) string {
-	return fmt.Sprintf("%032b", x)
+func uint64ToBits(x uint64) string {
+	return fmt.Sprintf("%064b", x)
 }
 
-func uint32SliceToBits(slice []uint32) []string {
+func uint64SliceToBits(slice []uint64) []string {
 	ret := make([]string, len(slice))
 	for i, x := range slice {
-		ret[i] = uint32ToBits(x)
+		ret[i] = uint64ToBits(x)
 	}
 
 	return ret
@@ -49,7 +52,7 @@ func TestRemoveRightmost1(t *testing.T) {
 	for _, tt := range cases {
 		title := fmt.Sprintf("input: %s, expected: %s", tt.bits, tt.expected)
 		t.Run(title, func(t *testing.T) {
-			assert.Equal(t, bitsToUint32(tt.expected)[0], removeRightmost1(bitsToUint32(tt.bits)[0]))
+			assert.Equal(t, bitsToUint64(tt.expected)[0], removeRightmost1(bitsToUint64(tt.bits)[0]))
 		})
 	}
 }
@@ -67,7 +70,7 @@ func TestExtractRightmost1(t *testing.T) {
 	for _, tt := range cases {
 		title := fmt.Sprintf("input: %s, expected: %s", tt.bits, tt.expected)
 		t.Run(title, func(t *testing.T) {
-			assert.Equal(t, bitsToUint32(tt.expected)[0], extractRightmost1(bitsToUint32(tt.bits)[0]))
+			assert.Equal(t, bitsToUint64(tt.expected)[0], extractRightmost1(bitsToUint64(tt.bits)[0]))
 		})
 	}
 }
@@ -85,7 +88,7 @@ func TestSmearRightmost1(t *testing.T) {
 	for _, tt := range cases {
 		title := fmt.Sprintf("input: %s, expected: %s", tt.bits, tt.expected)
 		t.Run(title, func(t *testing.T) {
-			assert.Equal(t, bitsToUint32(tt.expected)[0], smearRightmost1(bitsToUint32(tt.bits)[0]))
+			assert.Equal(t, bitsToUint64(tt.expected)[0], smearRightmost1(bitsToUint64(tt.bits)[0]))
 		})
 	}
 }
@@ -104,50 +107,26 @@ func TestBuildStructualCharacterBitmaps(t *testing.T) {
 	}{
 		{
 			text:          `{"id":"id:\"a\"","reviews":50,"a`,
-			backSlashBits: []string{"00000000000000000010010000000000"},
-			quoteBits:     []string{"01000010000000101100100001010010"},
-			colonBits:     []string{"00000100000000000000001000100000"},
-			lBraceBits:    []string{"00000000000000000000000000000001"},
-			rBraceBits:    []string{"00000000000000000000000000000000"},
-			commaBits:     []string{"00100000000000010000000000000000"},
-			lBracketBits:  []string{"00000000000000000000000000000000"},
-			rBracketBits:  []string{"00000000000000000000000000000000"},
+			backSlashBits: []string{"0000000000000000000000000000000000000000000000000010010000000000"},
+			quoteBits:     []string{"0000000000000000000000000000000001000010000000101100100001010010"},
+			colonBits:     []string{"0000000000000000000000000000000000000100000000000000001000100000"},
+			lBraceBits:    []string{"0000000000000000000000000000000000000000000000000000000000000001"},
+			rBraceBits:    []string{"0000000000000000000000000000000000000000000000000000000000000000"},
+			commaBits:     []string{"0000000000000000000000000000000000100000000000010000000000000000"},
+			lBracketBits:  []string{"0000000000000000000000000000000000000000000000000000000000000000"},
+			rBracketBits:  []string{"0000000000000000000000000000000000000000000000000000000000000000"},
 		},
 		{
 			text: `      {"id":"id:\"a\"","reviews"` +
 				`:50,"a`,
-			backSlashBits: []string{
-				"00000000000010010000000000000000",
-				"00000000000000000000000000000000",
-			},
-			quoteBits: []string{
-				"10000000101100100001010010000000",
-				"00000000000000000000000000010000",
-			},
-			colonBits: []string{
-				"00000000000000001000100000000000",
-				"00000000000000000000000000000001",
-			},
-			lBraceBits: []string{
-				"00000000000000000000000001000000",
-				"00000000000000000000000000000000",
-			},
-			rBraceBits: []string{
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000000",
-			},
-			commaBits: []string{
-				"00000000010000000000000000000000",
-				"00000000000000000000000000001000",
-			},
-			lBracketBits: []string{
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000000",
-			},
-			rBracketBits: []string{
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000000",
-			},
+			backSlashBits: []string{"0000000000000000000000000000000000000000000010010000000000000000"},
+			quoteBits:     []string{"0000000000000000000000000001000010000000101100100001010010000000"},
+			colonBits:     []string{"0000000000000000000000000000000100000000000000001000100000000000"},
+			lBraceBits:    []string{"0000000000000000000000000000000000000000000000000000000001000000"},
+			rBraceBits:    []string{"0000000000000000000000000000000000000000000000000000000000000000"},
+			commaBits:     []string{"0000000000000000000000000000100000000000010000000000000000000000"},
+			lBracketBits:  []string{"0000000000000000000000000000000000000000000000000000000000000000"},
+			rBracketBits:  []string{"0000000000000000000000000000000000000000000000000000000000000000"},
 		},
 	}
 
@@ -155,14 +134,14 @@ func TestBuildStructualCharacterBitmaps(t *testing.T) {
 		title := fmt.Sprintf("input: %s", tt.text)
 		t.Run(title, func(t *testing.T) {
 			expected := &structualCharacterBitmaps{
-				backslashes: bitsToUint32(tt.backSlashBits...),
-				quotes:      bitsToUint32(tt.quoteBits...),
-				colons:      bitsToUint32(tt.colonBits...),
-				lBraces:     bitsToUint32(tt.lBraceBits...),
-				rBraces:     bitsToUint32(tt.rBraceBits...),
-				commas:      bitsToUint32(tt.commaBits...),
-				lBrackets:   bitsToUint32(tt.lBracketBits...),
-				rBrackets:   bitsToUint32(tt.rBracketBits...),
+				backslashes: bitsToUint64(tt.backSlashBits...),
+				quotes:      bitsToUint64(tt.quoteBits...),
+				colons:      bitsToUint64(tt.colonBits...),
+				lBraces:     bitsToUint64(tt.lBraceBits...),
+				rBraces:     bitsToUint64(tt.rBraceBits...),
+				commas:      bitsToUint64(tt.commaBits...),
+				lBrackets:   bitsToUint64(tt.lBracketBits...),
+				rBrackets:   bitsToUint64(tt.rBracketBits...),
 			}
 
 			actual := buildStructualCharacterBitmaps([]byte(tt.text))
@@ -171,96 +150,210 @@ func TestBuildStructualCharacterBitmaps(t *testing.T) {
 	}
 }
 
+func TestMatchBytes(t *testing.T) {
+	for _, c := range []byte(`\":{},[]`) {
+		for b := 0; b < 256; b++ {
+			for k := uint(0); k < 8; k++ {
+				// the other bytes are c or its neighbor to detect carries between bytes
+				var v, expected uint64
+				for j := uint(0); j < 8; j++ {
+					x := c + byte(j%2)
+					if j == k {
+						x = byte(b)
+					}
+					v |= uint64(x) << (j * 8)
+					if x == c {
+						expected |= 1 << j
+					}
+				}
+				if actual := matchBytes(v, c); actual != expected {
+					t.Fatalf("matchBytes(%016x, %q): expected %08b, actual %08b", v, c, expected, actual)
+				}
+			}
+		}
+	}
+}
+
 func TestBuildStructualQuoteBitmap(t *testing.T) {
 	cases := []struct {
 		bitmaps  *structualCharacterBitmaps
-		expected []uint32
+		expected []uint64
 	}{
 		{
 			bitmaps: &structualCharacterBitmaps{
-				backslashes: bitsToUint32("00000000000000000010010000000000"),
-				quotes:      bitsToUint32("01000010000000101100100001010010"),
+				backslashes: bitsToUint64("0000000000000000000000000000000000000000000000000010010000000000"),
+				quotes:      bitsToUint64("0000000000000000000000000000000001000010000000101100100001010010"),
+			},
+			expected: bitsToUint64("0000000000000000000000000000000001000010000000101000000001010010"),
+		},
+		{
+			bitmaps: &structualCharacterBitmaps{
+				backslashes: bitsToUint64("0000000000000000000000000000000100000000000000000000000000000000"),
+				quotes:      bitsToUint64("0000000000000000000000000000001000000000000000000000000000000000"),
+			},
+			expected: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+		},
+		{
+			bitmaps: &structualCharacterBitmaps{
+				backslashes: bitsToUint64("0000000000000000000000000000000110000000000000000000000000000000"),
+				quotes:      bitsToUint64("0000000000000000000000000000001000000000000000000000000000000000"),
 			},
-			expected: bitsToUint32("01000010000000101000000001010010"),
+			expected: bitsToUint64("0000000000000000000000000000001000000000000000000000000000000000"),
 		},
 		{
 			bitmaps: &structualCharacterBitmaps{
-				backslashes: bitsToUint32(
-					"00000000000000000000000000000000",
-					"00000000000000000000000000000001",
+				backslashes: bitsToUint64(
+					"1111111111111111111111111111111110000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
 				),
-				quotes: bitsToUint32(
-					"00000000000000000000000000000000",
-					"00000000000000000000000000000010",
+				quotes: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000010",
 				),
 			},
-			expected: bitsToUint32(
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000000",
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000010",
 			),
 		},
 		{
+			// a backslash at the end of the word escapes the quote at the start of the next word
 			bitmaps: &structualCharacterBitmaps{
-				backslashes: bitsToUint32(
-					"10000000000000000000000000000000",
-					"00000000000000000000000000000001",
+				backslashes: bitsToUint64(
+					"1000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
-				quotes: bitsToUint32(
-					"00000000000000000000000000000000",
-					"00000000000000000000000000000010",
+				quotes: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
 				),
 			},
-			expected: bitsToUint32(
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000010",
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
 			),
 		},
 		{
+			// two backslashes across words do not escape the quote
 			bitmaps: &structualCharacterBitmaps{
-				backslashes: bitsToUint32(
-					"10000000000000000000000000000000",
-					"11111111111111111111111111111111",
-					"00000000000000000000000000000001",
+				backslashes: bitsToUint64(
+					"1100000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
-				quotes: bitsToUint32(
-					"00000000000000000000000000000000",
-					"00000000000000000000000000000000",
-					"00000000000000000000000000000010",
+				quotes: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
 				),
 			},
-			expected: bitsToUint32(
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000000",
-				"00000000000000000000000000000010",
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			),
+		},
+		{
+			// an odd number of backslashes across words escapes the quote
+			bitmaps: &structualCharacterBitmaps{
+				backslashes: bitsToUint64(
+					"1111111111111111111111111111111100000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
+				),
+				quotes: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000010",
+				),
+			},
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+			),
+		},
+		{
+			// an even number of backslashes across three words does not escape the quote
+			bitmaps: &structualCharacterBitmaps{
+				backslashes: bitsToUint64(
+					"1111111111111111111111111111111111111111111111111111111111100000",
+					"1111111111111111111111111111111111111111111111111111111111111111",
+					"0000000000000000000000000000000000000000000000000000000000000001",
+				),
+				quotes: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000010",
+				),
+			},
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000010",
 			),
 		},
 	}
 
 	for i, tt := range cases {
 		t.Run(fmt.Sprintf("case%d", i), func(t *testing.T) {
-			actual := buildStructualQuoteBitmap(tt.bitmaps)
-			assert.Equalf(t, tt.expected, actual, "expected: %s, actual: %s", uint32SliceToBits(tt.expected), uint32SliceToBits(actual))
+			actual, err := buildStructualQuoteBitmap(tt.bitmaps)
+			if assert.NoError(t, err) {
+				assert.Equalf(t, tt.expected, actual, "expected: %s, actual: %s", uint64SliceToBits(tt.expected), uint64SliceToBits(actual))
+			}
 		})
 	}
 }
 
 func TestBuildStringMaskBitmap(t *testing.T) {
 	cases := []struct {
-		quoteBitmap []uint32
-		expected    []uint32
+		quoteBitmap []uint64
+		expected    []uint64
 	}{
 		{
-			quoteBitmap: bitsToUint32("01000010000000101000000001010010"),
-			expected:    bitsToUint32("10000011111111001111111110011100"),
+			quoteBitmap: bitsToUint64("0000000000000000000000000000000001000010000000101000000001010010"),
+			expected:    bitsToUint64("1111111111111111111111111111111110000011111111001111111110011100"),
+		},
+		{
+			quoteBitmap: bitsToUint64("0000000001010000100000001010000000010100100000000000000000000000"),
+			expected:    bitsToUint64("0000000001100000111111110011111111100111000000000000000000000000"),
 		},
 		{
-			quoteBitmap: bitsToUint32(
-				"00010100100000000000000000000000",
-				"00000000010100001000000010100000",
+			quoteBitmap: bitsToUint64(
+				"1000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000001",
 			),
-			expected: bitsToUint32(
-				"11100111000000000000000000000000",
-				"00000000011000001111111100111111",
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"1111111111111111111111111111111111111111111111111111111111111111",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			),
+		},
+		{
+			quoteBitmap: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000010000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000001000000000000",
+			),
+			expected: bitsToUint64(
+				"1111111111111111111111111111111111111111111111111111100000000000",
+				"1111111111111111111111111111111111111111111111111111111111111111",
+				"0000000000000000000000000000000000000000000000000001111111111111",
+			),
+		},
+		{
+			quoteBitmap: bitsToUint64(
+				"1000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			),
+			expected: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+			),
+		},
+		{
+			quoteBitmap: bitsToUint64(
+				"1100000000000000000000000000000001000000000000000000000000000000",
+				"0000000000000000000000000001000000000000000000000000000000000000",
+			),
+			expected: bitsToUint64(
+				"0111111111111111111111111111111110000000000000000000000000000000",
+				"0000000000000000000000000001111111111111111111111111111111111111",
 			),
 		},
 	}
@@ -268,49 +361,83 @@ func TestBuildStringMaskBitmap(t *testing.T) {
 	for i, tt := range cases {
 		t.Run(fmt.Sprintf("case%d", i), func(t *testing.T) {
 			actual := buildStringMaskBitmap(tt.quoteBitmap)
-			assert.Equalf(t, tt.expected, actual, "expected: %s, actual: %s", uint32SliceToBits(tt.expected), uint32SliceToBits(actual))
+			assert.Equalf(t, tt.expected, actual, "expected: %s, actual: %s", uint64SliceToBits(tt.expected), uint64SliceToBits(actual))
+
+			withoutSIMD(func() { actual = buildStringMaskBitmap(tt.quoteBitmap) })
+			assert.Equalf(t, tt.expected, actual, "expected: %s, actual: %s", uint64SliceToBits(tt.expected), uint64SliceToBits(actual))
+		})
+	}
+}
+
+func TestPrefixXor(t *testing.T) {
+	cases := []struct {
+		bits     string
+		expected string
+	}{
+		{bits: "00100100", expected: "00011100"},
+		{bits: "10000001", expected: "01111111"},
+		{bits: "00000000", expected: "00000000"},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprintf("input: %s, expected: %s", tt.bits, tt.expected), func(t *testing.T) {
+			assert.Equal(t, bitsToUint64(tt.expected)[0], prefixXor(bitsToUint64(tt.bits)[0]))
 		})
 	}
 }
 
-func TestBuildLeveledColonBitmaps(t *testing.T) {
+func TestBuildLeveledBitmaps(t *testing.T) {
 	cases := []struct {
-		bitmaps    *structualCharacterBitmaps
-		stringMask []uint32
-		level      int
-		expected   [][]uint32
+		bitmaps        *structualCharacterBitmaps
+		stringMask     []uint64
+		level          int
+		expectedColons [][]uint64
+		expectedCommas [][]uint64
 	}{
 		{
 			// {"a":1,"b":{"c":2}}
 			// {{2:"c"}:"b",1:"a"}
 			bitmaps: &structualCharacterBitmaps{
-				colons: bitsToUint32(
-					"00000000000000001000010000010000",
-				),
-				lBraces: bitsToUint32("00000000000000000000100000000001"),
-				rBraces: bitsToUint32("00000000000001100000000000000000"),
+				colons:    bitsToUint64("0000000000000000000000000000000000000000000000001000010000010000"),
+				commas:    bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
+				lBraces:   bitsToUint64("0000000000000000000000000000000000000000000000000000100000000001"),
+				rBraces:   bitsToUint64("0000000000000000000000000000000000000000000001100000000000000000"),
+				lBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+				rBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
 			},
-			stringMask: bitsToUint32("00000000000000000110001100001100"),
+			stringMask: bitsToUint64("0000000000000000000000000000000000000000000000000110001100001100"),
 			level:      2,
-			expected: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
-				bitsToUint32("00000000000000001000010000010000"),
+			expectedColons: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000000000000000001000010000010000"),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
 			},
 		},
 		{
 			// {"a":1,"b":{"c":{"d":2},"e":3}}
 			// }}3:"e",}2:"d"{:"c"{:"b",1:"a"{
 			bitmaps: &structualCharacterBitmaps{
-				colons:  bitsToUint32("00001000000100001000010000010000"),
-				lBraces: bitsToUint32("00000000000000010000100000000001"),
-				rBraces: bitsToUint32("01100000010000000000000000000000"),
+				colons:    bitsToUint64("0000000000000000000000000000000000001000000100001000010000010000"),
+				commas:    bitsToUint64("0000000000000000000000000000000000000000100000000000000001000000"),
+				lBraces:   bitsToUint64("0000000000000000000000000000000000000000000000010000100000000001"),
+				rBraces:   bitsToUint64("0000000000000000000000000000000001100000010000000000000000000000"),
+				lBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+				rBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
 			},
-			stringMask: bitsToUint32("00000110000011000110001100001100"),
+			stringMask: bitsToUint64("0000000000000000000000000000000000000110000011000110001100001100"),
 			level:      3,
-			expected: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
-				bitsToUint32("00001000000000001000010000010000"),
-				bitsToUint32("00001000000100001000010000010000"),
+			expectedColons: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000000001000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000100001000010000010000"),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
+				bitsToUint64("0000000000000000000000000000000000000000100000000000000001000000"),
+				bitsToUint64("0000000000000000000000000000000000000000100000000000000001000000"),
 			},
 		},
 		{
@@ -319,36 +446,233 @@ func TestBuildLeveledColonBitmaps(t *testing.T) {
 			// "b",1:"a"{
 			//            }}3:"e",}2:"d"{:"c"{:
 			bitmaps: &structualCharacterBitmaps{
-				colons: bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000100000010000100001",
+				colons:    bitsToUint64("0000000000000010000001000010000100000100000000000000000000000000"),
+				commas:    bitsToUint64("0000000000000000001000000000000000010000000000000000000000000000"),
+				lBraces:   bitsToUint64("0000000000000000000000000100001000000000010000000000000000000000"),
+				rBraces:   bitsToUint64("0000000000011000000100000000000000000000000000000000000000000000"),
+				lBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+				rBrackets: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+			},
+			stringMask: bitsToUint64("0000000000000001100000110001100011000011000000000000000000000000"),
+			level:      3,
+			expectedColons: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000100000100000000000000000000000000"),
+				bitsToUint64("0000000000000010000000000010000100000100000000000000000000000000"),
+				bitsToUint64("0000000000000010000001000010000100000100000000000000000000000000"),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000010000000000000000000000000000"),
+				bitsToUint64("0000000000000000001000000000000000010000000000000000000000000000"),
+				bitsToUint64("0000000000000000001000000000000000010000000000000000000000000000"),
+			},
+		},
+		{
+			// {"a":[1,{"b":2,"c":3}],"d":[[4,5]]}
+			// ]]5,4[[:"d",]}3:"c",2:"b"{,1[:"a"{
+			bitmaps: &structualCharacterBitmaps{
+				colons:    bitsToUint64("0000000000000000000000000000000000000100000001000001000000010000"),
+				commas:    bitsToUint64("0000000000000000000000000000000001000000010000000100000010000000"),
+				lBraces:   bitsToUint64("0000000000000000000000000000000000000000000000000000000100000001"),
+				rBraces:   bitsToUint64("0000000000000000000000000000010000000000000100000000000000000000"),
+				lBrackets: bitsToUint64("0000000000000000000000000000000000011000000000000000000000100000"),
+				rBrackets: bitsToUint64("0000000000000000000000000000001100000000001000000000000000000000"),
+			},
+			stringMask: bitsToUint64("0000000000000000000000000000000000000011000000110000110000001100"),
+			level:      3,
+			expectedColons: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000100000000000000000000010000"),
+				bitsToUint64("0000000000000000000000000000000000000100000000000000000000010000"),
+				bitsToUint64("0000000000000000000000000000000000000100000001000001000000010000"),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000010000000000000000000000"),
+				bitsToUint64("0000000000000000000000000000000000000000010000000000000010000000"),
+				bitsToUint64("0000000000000000000000000000000001000000010000000100000010000000"),
+			},
+		},
+		{
+			// 54 spaces and {"a":1,"b":{"c":{"d":2},"e":3}}
+			bitmaps: &structualCharacterBitmaps{
+				colons: bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000010000100001",
 				),
-				lBraces: bitsToUint32(
-					"00000000010000000000000000000000",
-					"00000000000000000000000001000010",
+				commas: bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000010000000000000",
 				),
-				rBraces: bitsToUint32(
-					"00000000000000000000000000000000",
-					"00000000000110000001000000000000",
+				lBraces: bitsToUint64(
+					"0000000001000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000001000010",
+				),
+				rBraces: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000110000001000000000000",
+				),
+				lBrackets: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+				),
+				rBrackets: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
 			},
-			stringMask: bitsToUint32(
-				"11000011000000000000000000000000",
-				"00000000000000011000001100011000",
+			stringMask: bitsToUint64(
+				"1100001100000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000011000001100011000",
 			),
 			level: 3,
-			expected: [][]uint32{
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000000000000000000001",
+			expectedColons: [][]uint64{
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
+				),
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000000000100001",
+				),
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000010000100001",
+				),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000100000000000100001",
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000010000000000000",
 				),
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000100000010000100001",
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000010000000000000",
+				),
+			},
+		},
+		{
+			// 40 spaces and {"a":[1,{"b":2,"c":3}],"d":[[4,5]]}
+			bitmaps: &structualCharacterBitmaps{
+				colons: bitsToUint64(
+					"0000010000010000000100000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000100",
+				),
+				commas: bitsToUint64(
+					"0100000001000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000001000000",
+				),
+				lBraces: bitsToUint64(
+					"0000000000000001000000010000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+				),
+				rBraces: bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000010000000000",
+				),
+				lBrackets: bitsToUint64(
+					"0000000000000000001000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000011000",
+				),
+				rBrackets: bitsToUint64(
+					"0010000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000001100000000",
+				),
+			},
+			stringMask: bitsToUint64(
+				"0000001100001100000011000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000011",
+			),
+			level: 3,
+			expectedColons: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000100000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000100",
+				),
+				bitsToUint64(
+					"0000000000000000000100000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000100",
+				),
+				bitsToUint64(
+					"0000010000010000000100000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000100",
+				),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64(
+					"0100000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+				),
+				bitsToUint64(
+					"0100000000000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+				),
+				bitsToUint64(
+					"0100000001000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000001000000",
+				),
+			},
+		},
+		{
+			// {"k":"<50 spaces>{:,[<10 spaces>","b":{"c":[1,{"d":2}]},"e":3}
+			bitmaps: &structualCharacterBitmaps{
+				colons: bitsToUint64(
+					"0000001000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000001000000001000000010000100000000000",
+				),
+				commas: bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000100000000010000000000010000000",
+				),
+				lBraces: bitsToUint64(
+					"0000000100000000000000000000000000000000000000000000000000000001",
+					"0000000000000000000000000000000000000000000100000001000000000000",
+				),
+				rBraces: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000100000010100000000000000000000000000",
+				),
+				lBrackets: bitsToUint64(
+					"0000100000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000000000000000",
+				),
+				rBrackets: bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000001000000000000000000000000000",
+				),
+			},
+			stringMask: bitsToUint64(
+				"1111111111111111111111111111111111111111111111111111111111001100",
+				"0000000000000000000000000000000110000000110000001100011001111111",
+			),
+			level: 3,
+			expectedColons: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000001000000000000000000000100000000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000001000000000000000010000100000000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000001000000000000000010000100000000000",
+				),
+			},
+			expectedCommas: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000100000000000000000000010000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000100000000000000000000010000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000100000000010000000000010000000",
 				),
 			},
 		},
@@ -356,17 +680,30 @@ func TestBuildLeveledColonBitmaps(t *testing.T) {
 
 	for i, tt := range cases {
 		t.Run(fmt.Sprintf("case%d", i), func(t *testing.T) {
-			actual, err := buildLeveledColonBitmaps(tt.bitmaps, tt.stringMask, tt.level)
+			colons, commas, err := buildLeveledBitmaps(tt.bitmaps, tt.stringMask, tt.level, nil)
 			if assert.NoError(t, err) {
-				assert.Equal(t, tt.expected, actual)
+				assert.Equal(t, tt.expectedColons, colons)
+				assert.Equal(t, tt.expectedCommas, commas)
 			}
 		})
 	}
+
+	errCases := []string{`{"a":1}}`, `{"a":[1}`, `{"a":{"b":1]}`, `{"a":[1]`}
+
+	for i, json := range errCases {
+		t.Run(fmt.Sprintf("errCase%d: %s", i, json), func(t *testing.T) {
+			bitmaps := buildStructualCharacterBitmaps([]byte(json))
+			quoteBitmap, _ := buildStructualQuoteBitmap(bitmaps)
+			stringMask := buildStringMaskBitmap(quoteBitmap)
+			_, _, err := buildLeveledBitmaps(bitmaps, stringMask, 2, nil)
+			assert.Error(t, err)
+		})
+	}
 }
 
 func TestGenerateColonPositions(t *testing.T) {
 	cases := []struct {
-		index    [][]uint32
+		index    [][]uint64
 		start    int
 		end      int
 		level    int
@@ -375,10 +712,10 @@ func TestGenerateColonPositions(t *testing.T) {
 		{
 			// {"a":1,"b":{"c":{"d":2},"e":3}}
 			// }}3:"e",}2:"d"{:"c"{:"b",1:"a"{
-			index: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
-				bitsToUint32("00001000000000001000010000010000"),
-				bitsToUint32("00001000000100001000010000010000"),
+			index: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000000001000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000100001000010000010000"),
 			},
 			start:    0,
 			end:      31,
@@ -386,10 +723,10 @@ func TestGenerateColonPositions(t *testing.T) {
 			expected: []int{4, 10},
 		},
 		{
-			index: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
-				bitsToUint32("00001000000000001000010000010000"),
-				bitsToUint32("00001000000100001000010000010000"),
+			index: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000000001000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000001000000100001000010000010000"),
 			},
 			start:    11,
 			end:      30,
@@ -406,49 +743,200 @@ func TestGenerateColonPositions(t *testing.T) {
 	}
 }
 
+func TestNextPosition(t *testing.T) {
+	cases := []struct {
+		bitmap   []uint64
+		start    int
+		end      int
+		expected int
+	}{
+		{
+			bitmap:   bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+			start:    0,
+			end:      31,
+			expected: 4,
+		},
+		{
+			bitmap:   bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+			start:    5,
+			end:      31,
+			expected: 10,
+		},
+		{
+			bitmap:   bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+			start:    5,
+			end:      9,
+			expected: -1,
+		},
+		{
+			bitmap: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000010000",
+				"0000000000000000000000000000000000000000000000000000000000000100",
+			),
+			start:    5,
+			end:      95,
+			expected: 66,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d", i), func(t *testing.T) {
+			assert.Equal(t, tt.expected, nextPosition(tt.bitmap, tt.start, tt.end))
+		})
+	}
+}
+
 func TestBuildStructualIndex(t *testing.T) {
 	cases := []struct {
 		input               string
 		level               int
-		stringMaskBitmap    []uint32
-		leveledColonBitmaps [][]uint32
+		stringMaskBitmap    []uint64
+		leveledColonBitmaps [][]uint64
+		leveledCommaBitmaps [][]uint64
 	}{
 		{
 			input:            `{"a":1,"b":{"c":2}}`,
 			level:            2,
-			stringMaskBitmap: bitsToUint32("00000000000000000110001100001100"),
-			leveledColonBitmaps: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
-				bitsToUint32("00000000000000001000010000010000"),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000110001100001100"),
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+				bitsToUint64("0000000000000000000000000000000000000000000000001000010000010000"),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
 			},
 		},
 		{
 			input:            `{"a":1,"b":{"c":2}}`,
 			level:            1,
-			stringMaskBitmap: bitsToUint32("00000000000000000110001100001100"),
-			leveledColonBitmaps: [][]uint32{
-				bitsToUint32("00000000000000000000010000010000"),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000110001100001100"),
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000010000010000"),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000000000000000000000000001000000"),
+			},
+		},
+		{
+			input:            `                      {"a":1,"b":{"c":{"d":2},"e":3}}`,
+			level:            3,
+			stringMaskBitmap: bitsToUint64("0000000000000001100000110001100011000011000000000000000000000000"),
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000100000100000000000000000000000000"),
+				bitsToUint64("0000000000000010000000000010000100000100000000000000000000000000"),
+				bitsToUint64("0000000000000010000001000010000100000100000000000000000000000000"),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64("0000000000000000000000000000000000010000000000000000000000000000"),
+				bitsToUint64("0000000000000000001000000000000000010000000000000000000000000000"),
+				bitsToUint64("0000000000000000001000000000000000010000000000000000000000000000"),
+			},
+		},
+		{
+			input: strings.Repeat(" ", 54) + `{"a":1,"b":{"c":{"d":2},"e":3}}`,
+			level: 3,
+			stringMaskBitmap: bitsToUint64(
+				"1100001100000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000011000001100011000",
+			),
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000001",
+				),
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000000000100001",
+				),
+				bitsToUint64(
+					"0000010000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000100000010000100001",
+				),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
+				),
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000010000000000000",
+				),
+				bitsToUint64(
+					"0001000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000010000000000000",
+				),
+			},
+		},
+		{
+			input: `{"a":"` + strings.Repeat("x", 57) + `\":{","b":[1,{"c":2}]}`,
+			level: 2,
+			stringMaskBitmap: bitsToUint64(
+				"1111111111111111111111111111111111111111111111111111111111001100",
+				"0000000000000000000000000000000000000000000000001100000011001111",
+			),
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000000000000000000000000000000100000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000000000000000000000000000000000000000000100000000",
+				),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000010000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000100000010000",
+				),
 			},
 		},
 		{
-			input: `                      {"a":1,"b":{"c":{"d":2},"e":3}}`,
+			input: `{"a":"` + strings.Repeat("x", 100) + `","b":[1,{"c":2}],` + strings.Repeat(" ", 40) + `"d":{"e":[[3]]}}`,
 			level: 3,
-			stringMaskBitmap: bitsToUint32(
-				"11000011000000000000000000000000",
-				"00000000000000011000001100011000",
+			stringMaskBitmap: bitsToUint64(
+				"1111111111111111111111111111111111111111111111111111111111001100",
+				"0000000001100000011001111111111111111111111111111111111111111111",
+				"0000000000000000000011000110000000000000000000000000000000000000",
 			),
-			leveledColonBitmaps: [][]uint32{
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000000000000000000001",
+			leveledColonBitmaps: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000000001000000000000000000000000000000000000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000000000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000100001000000000000000000000000000000000000000",
+				),
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000010000",
+					"0000000010000000100000000000000000000000000000000000000000000000",
+					"0000000000000000000100001000000000000000000000000000000000000000",
+				),
+			},
+			leveledCommaBitmaps: [][]uint64{
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000100000000000000010000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000100000000000100001",
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000100000000100000010000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
-				bitsToUint32(
-					"00000100000000000000000000000000",
-					"00000000000000100000010000100001",
+				bitsToUint64(
+					"0000000000000000000000000000000000000000000000000000000000000000",
+					"0000100000000100000010000000000000000000000000000000000000000000",
+					"0000000000000000000000000000000000000000000000000000000000000000",
 				),
 			},
 		},
@@ -456,13 +944,14 @@ func TestBuildStructualIndex(t *testing.T) {
 
 	for i, tt := range cases {
 		t.Run(fmt.Sprintf("case%d: %s", i, tt.input), func(t *testing.T) {
-			actual, err := buildStructualIndex([]byte(tt.input), tt.level)
+			actual, err := buildStructualIndex([]byte(tt.input), tt.level, nil)
 			if assert.NoError(t, err) {
 				expected := &structualIndex{
 					json:                []byte(tt.input),
 					level:               tt.level,
 					stringMaskBitmap:    tt.stringMaskBitmap,
 					leveledColonBitmaps: tt.leveledColonBitmaps,
+					leveledCommaBitmaps: tt.leveledCommaBitmaps,
 				}
 				assert.Equal(t, expected, actual)
 			}
@@ -473,69 +962,169 @@ func TestBuildStructualIndex(t *testing.T) {
 func TestRetrieveFieldName(t *testing.T) {
 	cases := []struct {
 		json             []byte
-		stringMaskBitmap []uint32
+		stringMaskBitmap []uint64
 		colon            int
 		expected         string
 	}{
 		{
 			json:             []byte(`{"abc":1}`),
-			stringMaskBitmap: bitsToUint32("00000000000000000000000000111100"),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000000000111100"),
 			colon:            6,
 			expected:         "abc",
 		},
 		{
 			json:             []byte(`{"\\\"abc\"\\":1}`),
-			stringMaskBitmap: bitsToUint32("00000000000000000011111111111100"),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000011111111111100"),
 			colon:            14,
 			expected:         `\"abc"\`,
 		},
 		{
-			json: []byte(`{                         "abc" ` + ` : 1}`),
-			stringMaskBitmap: bitsToUint32(
-				"01111000000000000000000000000000",
-				"00000000000000000000000000000000",
+			json:             []byte(`{                         "abc" ` + ` : 1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000001111000000000000000000000000000"),
+			colon:            33,
+			expected:         "abc",
+		},
+		{
+			json:             []byte(`{                            "ab` + `c":1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000001111000000000000000000000000000000"),
+			colon:            34,
+			expected:         "abc",
+		},
+		{
+			json: []byte(`{                            "ab` + `                                ` + `c":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1111111111111111111111111111111111000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000011",
 			),
-			colon:    33,
+			colon:    66,
+			expected: "ab                                c",
+		},
+		{
+			json:             []byte(`{                              "` + `abc":1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000111100000000000000000000000000000000"),
+			colon:            36,
+			expected:         "abc",
+		},
+		{
+			json:             []byte(`{                              "` + `abc":1,"d":2}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000001100000111100000000000000000000000000000000"),
+			colon:            36,
+			expected:         "abc",
+		},
+		{
+			json:             []byte(`{"\u0061\/":1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000011111111100"),
+			colon:            11,
+			expected:         "a/",
+		},
+		{
+			json: []byte(`{` + strings.Repeat(" ", 60) + `"abc":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1100000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000011",
+			),
+			colon:    66,
 			expected: "abc",
 		},
 		{
-			json: []byte(`{                            "ab` + `c":1}`),
-			stringMaskBitmap: bitsToUint32(
-				"11000000000000000000000000000000",
-				"00000000000000000000000000000011",
+			json: []byte(`{` + strings.Repeat(" ", 62) + `"abc":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000001111",
 			),
-			colon:    34,
+			colon:    68,
 			expected: "abc",
 		},
 		{
-			json: []byte(`{                            "ab` + `                                ` + `c":1}`),
-			stringMaskBitmap: bitsToUint32(
-				"11000000000000000000000000000000",
-				"11111111111111111111111111111111",
-				"00000000000000000000000000000011",
+			json: []byte(`{` + strings.Repeat(" ", 61) + `"abc":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000111",
 			),
-			colon:    66,
-			expected: "ab                                c",
+			colon:    67,
+			expected: "abc",
+		},
+		{
+			json: []byte(`{` + strings.Repeat(" ", 58) + `"\\\"abc\"\\":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1111000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000011111111",
+			),
+			colon:    72,
+			expected: `\"abc"\`,
+		},
+		{
+			json: []byte(`{` + strings.Repeat(" ", 60) + `"ab` + strings.Repeat(" ", 64) + `c":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1100000000000000000000000000000000000000000000000000000000000000",
+				"1111111111111111111111111111111111111111111111111111111111111111",
+				"0000000000000000000000000000000000000000000000000000000000000011",
+			),
+			colon:    130,
+			expected: "ab" + strings.Repeat(" ", 64) + "c",
 		},
 		{
-			json: []byte(`{                              "` + `abc":1}`),
-			stringMaskBitmap: bitsToUint32(
-				"00000000000000000000000000000000",
-				"00000000000000000000000000001111",
+			json: []byte(`{"x":"` + strings.Repeat(" ", 100) + `","abc":1}`),
+			stringMaskBitmap: bitsToUint64(
+				"1111111111111111111111111111111111111111111111111111111111001100",
+				"0000000000000001111001111111111111111111111111111111111111111111",
 			),
-			colon:    36,
+			colon:    113,
 			expected: "abc",
 		},
 	}
 
 	for i, tt := range cases {
 		t.Run(fmt.Sprintf("case%d: %s(%d)", i, tt.json, tt.colon), func(t *testing.T) {
-			actual, err := retrieveFieldName(tt.json, tt.stringMaskBitmap, tt.colon)
+			actual, err := retrieveFieldName(tt.json, tt.stringMaskBitmap, tt.colon, literalOptions{})
 			if assert.NoError(t, err) {
-				assert.Equalf(t, tt.expected, actual, "expected: %q, actual: %q", tt.expected, actual)
+				assert.Equalf(t, tt.expected, string(actual), "expected: %q, actual: %q", tt.expected, actual)
 			}
 		})
 	}
+
+	errCases := []struct {
+		json             []byte
+		stringMaskBitmap []uint64
+		colon            int
+	}{
+		{
+			json:             []byte(`{"\x41":1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000000001111100"),
+			colon:            7,
+		},
+		{
+			json:             []byte(`{"\'a":1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000000001111100"),
+			colon:            7,
+		},
+		{
+			json:             []byte("{\"\ta\":1}"),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000000000011100"),
+			colon:            5,
+		},
+		{
+			json:             []byte(`{1:1}`),
+			stringMaskBitmap: bitsToUint64("0000000000000000000000000000000000000000000000000000000000000000"),
+			colon:            2,
+		},
+	}
+
+	for i, tt := range errCases {
+		t.Run(fmt.Sprintf("errCase%d: %s(%d)", i, tt.json, tt.colon), func(t *testing.T) {
+			_, err := retrieveFieldName(tt.json, tt.stringMaskBitmap, tt.colon, literalOptions{})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("no allocation without escape", func(t *testing.T) {
+		json := []byte(`{"abc":1}`)
+		stringMaskBitmap := bitsToUint64("0000000000000000000000000000000000000000000000000000000000111100")
+		allocs := testing.AllocsPerRun(100, func() {
+			retrieveFieldName(json, stringMaskBitmap, 6, literalOptions{})
+		})
+		assert.Equal(t, 0.0, allocs)
+	})
 }
 
 func TestBuildQueriedFieldTable(t *testing.T) {
@@ -619,6 +1208,9 @@ func TestBuildQueriedFieldTable(t *testing.T) {
 		{
 			queriedFields: []string{"abc", "abc"},
 		},
+		{
+			queriedFields: []string{},
+		},
 	}
 
 	for i, tt := range errCases {
@@ -629,6 +1221,90 @@ func TestBuildQueriedFieldTable(t *testing.T) {
 	}
 }
 
+func TestParseLiteral(t *testing.T) {
+	cases := []struct {
+		json     string
+		value    interface{}
+		rawValue string
+		jsonType JSONType
+	}{
+		{json: `:0}`, value: 0.0, rawValue: "0", jsonType: JSONNumber},
+		{json: `:-0.5,`, value: -0.5, rawValue: "-0.5", jsonType: JSONNumber},
+		{json: `:1e10]`, value: 1e10, rawValue: "1e10", jsonType: JSONNumber},
+		{json: `: 2.5E-3 }`, value: 2.5e-3, rawValue: "2.5E-3", jsonType: JSONNumber},
+		{json: `:-1E+2`, value: -100.0, rawValue: "-1E+2", jsonType: JSONNumber},
+		{json: `:120`, value: 120.0, rawValue: "120", jsonType: JSONNumber},
+		{json: `:true }`, value: true, rawValue: "true", jsonType: JSONBool},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			v, rv, jsonType, err := parseLiteral([]byte(tt.json), 0, literalOptions{})
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.value, v)
+				assert.Equal(t, tt.rawValue, rv)
+				assert.Equal(t, tt.jsonType, jsonType)
+			}
+		})
+	}
+
+	errCases := []string{`:012`, `:-`, `:1.`, `:.5`, `:1e`, `:1e+`, `:+1`, `:1e400`, `:truex`, `:01.5}`, `:`}
+
+	for i, json := range errCases {
+		t.Run(fmt.Sprintf("errCase%d: %s", i, json), func(t *testing.T) {
+			_, _, _, err := parseLiteral([]byte(json), 0, literalOptions{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestUnescapeString(t *testing.T) {
+	cases := []struct {
+		body     string
+		policy   LoneSurrogatePolicy
+		expected string
+	}{
+		{body: `abc`, expected: "abc"},
+		{body: `\"\\\/\b\f\n\r\t`, expected: "\"\\/\b\f\n\r\t"},
+		{body: `\u0041\u00e9\u3042`, expected: "Aéあ"},
+		{body: `\uD83D\uDE00!`, expected: "😀!"},
+		{body: `\ud83d\ude00`, expected: "😀"},
+		{body: `a\uD83Db`, expected: "a\uFFFDb"},
+		{body: `\uDE00\uD83D`, expected: "\uFFFD\uFFFD"},
+		{body: `\uD83D\u0041`, expected: "\uFFFDA"},
+		{body: `\uD83D`, expected: "\uFFFD"},
+		{body: `\uD83D\uDE00`, policy: LoneSurrogateError, expected: "😀"},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.body), func(t *testing.T) {
+			actual, err := unescapeString([]byte(tt.body), literalOptions{loneSurrogate: tt.policy})
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, actual)
+			}
+		})
+	}
+
+	errCases := []struct {
+		body   string
+		policy LoneSurrogatePolicy
+	}{
+		{body: `\u00`},
+		{body: `\u00g0`},
+		{body: `\x41`},
+		{body: `abc\`},
+		{body: `\uD83D`, policy: LoneSurrogateError},
+		{body: `\uDE00\uD83D`, policy: LoneSurrogateError},
+	}
+
+	for i, tt := range errCases {
+		t.Run(fmt.Sprintf("errCase%d: %s", i, tt.body), func(t *testing.T) {
+			_, err := unescapeString([]byte(tt.body), literalOptions{loneSurrogate: tt.policy})
+			assert.Error(t, err)
+		})
+	}
+}
+
 func TestParserState(t *testing.T) {
 	cases := []struct {
 		json          []byte
@@ -638,32 +1314,37 @@ func TestParserState(t *testing.T) {
 		{
 			json:          []byte(`{"b":2,"c":-3,"a":1}`),
 			queriedFields: []string{"a", "c"},
-			expected:      []*KeyValue{{1, -3.0, "-3", JSONNumber}, {0, 1.0, "1", JSONNumber}},
+			expected:      []*KeyValue{{FieldID: 1, Value: -3.0, RawValue: "-3", Type: JSONNumber}, {FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}},
 		},
 		{
 			json:          []byte(`{"abcdef": {"id": "1111", "name": "autopp"}}`),
 			queriedFields: []string{"abcdef.id", "abcdef.name"},
-			expected:      []*KeyValue{{0, "1111", `"1111"`, JSONString}, {1, "autopp", `"autopp"`, JSONString}},
+			expected:      []*KeyValue{{FieldID: 0, Value: "1111", RawValue: `"1111"`, Type: JSONString}, {FieldID: 1, Value: "autopp", RawValue: `"autopp"`, Type: JSONString}},
 		},
 		{
 			json:          []byte(`{"a":1.0,"b":{"c":2}}`),
 			queriedFields: []string{"a", "b.c"},
-			expected:      []*KeyValue{{0, 1.0, "1.0", JSONNumber}, {1, 2.0, "2", JSONNumber}},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1.0", Type: JSONNumber}, {FieldID: 1, Value: 2.0, RawValue: "2", Type: JSONNumber}},
 		},
 		{
 			json:          []byte(`{"a":true,"b":false,"c":null}`),
 			queriedFields: []string{"a", "b", "c"},
-			expected:      []*KeyValue{{0, true, "true", JSONBool}, {1, false, "false", JSONBool}, {2, nil, "null", JSONNull}},
+			expected:      []*KeyValue{{FieldID: 0, Value: true, RawValue: "true", Type: JSONBool}, {FieldID: 1, Value: false, RawValue: "false", Type: JSONBool}, {FieldID: 2, Value: nil, RawValue: "null", Type: JSONNull}},
 		},
 		{
 			json:          []byte(`{"a":"foo","b":"bar\"\\\n\\n"}`),
 			queriedFields: []string{"a", "b"},
-			expected:      []*KeyValue{{0, "foo", `"foo"`, JSONString}, {1, "bar\"\\\n\\n", `"bar\"\\\n\\n"`, JSONString}},
+			expected:      []*KeyValue{{FieldID: 0, Value: "foo", RawValue: `"foo"`, Type: JSONString}, {FieldID: 1, Value: "bar\"\\\n\\n", RawValue: `"bar\"\\\n\\n"`, Type: JSONString}},
 		},
 		{
 			json:          []byte(`{"a":"\b\f\t\r"}`),
 			queriedFields: []string{"a"},
-			expected:      []*KeyValue{{0, "\b\f\t\r", `"\b\f\t\r"`, JSONString}},
+			expected:      []*KeyValue{{FieldID: 0, Value: "\b\f\t\r", RawValue: `"\b\f\t\r"`, Type: JSONString}},
+		},
+		{
+			json:          []byte(`{"a":"\u3053\u3093\uD83D\uDE00","b":"\uDE00"}`),
+			queriedFields: []string{"a", "b"},
+			expected:      []*KeyValue{{FieldID: 0, Value: "こん😀", RawValue: `"\u3053\u3093\uD83D\uDE00"`, Type: JSONString}, {FieldID: 1, Value: "\uFFFD", RawValue: `"\uDE00"`, Type: JSONString}},
 		},
 		{
 			json:          []byte(`{"a":0,"b":1}`),
@@ -673,29 +1354,287 @@ func TestParserState(t *testing.T) {
 		{
 			json:          []byte(`{"a":{"b":0}}`),
 			queriedFields: []string{"a"},
+			expected:      []*KeyValue{{FieldID: 0, Value: json.RawMessage(`{"b":0}`), RawValue: `{"b":0}`, Type: JSONObject}},
+		},
+		{
+			json:          []byte(`{"tags":["x", "y,]" ,"z"],"n":1}`),
+			queriedFields: []string{"tags[]", "n"},
+			expected:      []*KeyValue{{FieldID: 0, Value: "x", RawValue: `"x"`, Type: JSONString}, {FieldID: 0, Value: "y,]", RawValue: `"y,]"`, Type: JSONString}, {FieldID: 0, Value: "z", RawValue: `"z"`, Type: JSONString}, {FieldID: 1, Value: 1.0, RawValue: "1", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":[]}`),
+			queriedFields: []string{"a[]"},
+			expected:      []*KeyValue{},
+		},
+		{
+			json:          []byte(`{"items":[{"price":1,"name":"a"},{"name":"b"},{"price":{"x":2}},{"name":"c","price":3}]}`),
+			queriedFields: []string{"items[].price"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: json.RawMessage(`{"x":2}`), RawValue: `{"x":2}`, Type: JSONObject}, {FieldID: 0, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":[[1,2],[],[[3]],4,[5]]}`),
+			queriedFields: []string{"a[][]"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 0, Value: json.RawMessage(`[3]`), RawValue: "[3]", Type: JSONArray}, {FieldID: 0, Value: 5.0, RawValue: "5", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":[{"b":[{"c":1},{"c":2}]},{"b":[{"c":3}]}],"d":{"c":4}}`),
+			queriedFields: []string{"a[].b[].c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 0, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":{"b":1},"c":[1]}`),
+			queriedFields: []string{"a[]", "c.d"},
 			expected:      []*KeyValue{},
 		},
+		{
+			json:          []byte(`{"a":[{"x":"` + strings.Repeat("-", 80) + `","b":1},{"b":2}],"c":3}`),
+			queriedFields: []string{"a[].b", "c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 1, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{ "a" : [ { "b" : [ 1 , "2" ] } , { "b" : [ ] } , { "b" : [ {"c":[3,4]} , 5 ] } ] }`),
+			queriedFields: []string{"a[].b[]"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: "2", RawValue: `"2"`, Type: JSONString}, {FieldID: 0, Value: json.RawMessage(`{"c":[3,4]}`), RawValue: `{"c":[3,4]}`, Type: JSONObject}, {FieldID: 0, Value: 5.0, RawValue: "5", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":{"b":[{"c":1}],"c":2}}`),
+			queriedFields: []string{"a.c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":{"x":"}]","y":[1,{"z":null}]} ,"b":[ ],"c":[{"a":[]}]}`),
+			queriedFields: []string{"a", "b", "c[].a"},
+			expected: []*KeyValue{
+				{FieldID: 0, Value: json.RawMessage(`{"x":"}]","y":[1,{"z":null}]}`), RawValue: `{"x":"}]","y":[1,{"z":null}]}`, Type: JSONObject},
+				{FieldID: 1, Value: json.RawMessage(`[ ]`), RawValue: "[ ]", Type: JSONArray},
+				{FieldID: 2, Value: json.RawMessage(`[]`), RawValue: "[]", Type: JSONArray},
+			},
+		},
+		{
+			json:          []byte(`{"a":1,"b":{"c":2},"a":3}`),
+			queriedFields: []string{"a", "b.c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 1, Value: 2.0, RawValue: "2", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":1,"a":2,"c":3,"a":4}`),
+			queriedFields: []string{"a", "c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 1, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
+		{
+			json:          []byte(`{"a":[1,2],"b":[{"c":3}],"d":tru,"a":[5]}`),
+			queriedFields: []string{"a[]", "b[].c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 1, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
 	}
 
 	for i, tt := range cases {
-		t.Run(fmt.Sprintf("case%d (%s,%s)", i, tt.json, tt.queriedFields), func(t *testing.T) {
-			p, err := NewParser(tt.queriedFields)
+		for _, pruned := range []bool{false, true} {
+			tt := tt
+			options := []ParserOption{}
+			if pruned {
+				options = append(options, WithPrunedIndex())
+			}
+			t.Run(fmt.Sprintf("case%d (%s,%s,pruned=%v)", i, tt.json, tt.queriedFields, pruned), func(t *testing.T) {
+				testParserState(t, tt.json, tt.queriedFields, options, tt.expected)
+			})
+		}
+	}
+}
+
+func TestKeyValuePositions(t *testing.T) {
+	record := []byte("{\n  \"a\": [ {\"b\": [1, {\"c\":true}]}, {\"b\":[null]} ],\n  \"d\" : \"x\",\n  \"e\":{\"f\":[[0],[1, 2]]}}")
+	queriedFields := []string{"a[].b[]", "d", "e.f[][]"}
+	expected := []*KeyValue{
+		{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber, Start: 18, End: 19, KeyOffset: 12, Indices: []int{0, 0}},
+		{FieldID: 0, Value: json.RawMessage(`{"c":true}`), RawValue: `{"c":true}`, Type: JSONObject, Start: 21, End: 31, KeyOffset: 12, Indices: []int{0, 1}},
+		{FieldID: 0, Value: nil, RawValue: "null", Type: JSONNull, Start: 41, End: 45, KeyOffset: 36, Indices: []int{1, 0}},
+		{FieldID: 1, Value: "x", RawValue: `"x"`, Type: JSONString, Start: 59, End: 62, KeyOffset: 53},
+		{FieldID: 2, Value: 0.0, RawValue: "0", Type: JSONNumber, Start: 77, End: 78, KeyOffset: 71, Indices: []int{0, 0}},
+		{FieldID: 2, Value: 1.0, RawValue: "1", Type: JSONNumber, Start: 81, End: 82, KeyOffset: 71, Indices: []int{1, 0}},
+		{FieldID: 2, Value: 2.0, RawValue: "2", Type: JSONNumber, Start: 84, End: 85, KeyOffset: 71, Indices: []int{1, 1}},
+	}
+	for _, kv := range expected {
+		assert.Equal(t, kv.RawValue, string(record[kv.Start:kv.End]))
+		assert.Equal(t, byte('"'), record[kv.KeyOffset])
+	}
+
+	for _, trained := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trained=%v", trained), func(t *testing.T) {
+			p, err := NewParser(queriedFields)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if trained && !assert.NoError(t, p.Train([][]byte{record})) {
+				return
+			}
+			actual, err := parseRecord(p.NewParserState(), record)
 			if assert.NoError(t, err) {
-				ps, err := p.StartParse(tt.json)
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		p, err := NewParser(queriedFields)
+		if !assert.NoError(t, err) {
+			return
+		}
+		actual, err := collectStreamKeyValues(p.StartStreamParse(bytes.NewReader(record), 64))
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, actual)
+		}
+	})
+}
+
+func testParserState(t *testing.T, json []byte, queriedFields []string, options []ParserOption, expected []*KeyValue) {
+	p, err := NewParser(queriedFields, options...)
+	if assert.NoError(t, err) {
+		ps, err := p.StartParse(json)
+		if assert.NoError(t, err) {
+			actual := make([]*KeyValue, 0)
+			for {
+				kv, err := ps.Next()
 				if assert.NoError(t, err) {
-					actual := make([]*KeyValue, 0)
+					if kv.IsEndOfRecord() {
+						break
+					}
+					actual = append(actual, kv)
+				}
+			}
+			assert.Equal(t, expected, clearPositions(actual))
+		}
+	}
+}
+
+/*
+clearPositions clears the offsets and the indices of kvs to compare only the values.
+*/
+func clearPositions(kvs []*KeyValue) []*KeyValue {
+	cleared := make([]*KeyValue, len(kvs))
+	for i, kv := range kvs {
+		cleared[i] = &KeyValue{FieldID: kv.FieldID, Value: kv.Value, RawValue: kv.RawValue, Type: kv.Type}
+	}
+	return cleared
+}
+
+func TestNewIndexLevels(t *testing.T) {
+	cases := []struct {
+		queriedFields []string
+		colons        []bool
+		commas        []bool
+	}{
+		{queriedFields: []string{"a", "b"}, colons: []bool{true}, commas: []bool{true}},
+		{queriedFields: []string{"a.b.c"}, colons: []bool{true, true, true}, commas: []bool{true, true, true}},
+		{queriedFields: []string{"a[]"}, colons: []bool{true, false}, commas: []bool{true, true}},
+		{queriedFields: []string{"a[][].b", "c"}, colons: []bool{true, false, false, true}, commas: []bool{true, true, true, true}},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d %s", i, tt.queriedFields), func(t *testing.T) {
+			table, level, err := buildQueriedFieldTable(tt.queriedFields)
+			if assert.NoError(t, err) {
+				levels := newIndexLevels(table, level)
+				assert.Equal(t, tt.colons, levels.colons)
+				assert.Equal(t, tt.commas, levels.commas)
+			}
+		})
+	}
+}
+
+func TestWithLoneSurrogatePolicy(t *testing.T) {
+	p, err := NewParser([]string{"a"}, WithLoneSurrogatePolicy(LoneSurrogateError))
+	if assert.NoError(t, err) {
+		ps, err := p.StartParse([]byte(`{"a":"\uD83D"}`))
+		if assert.NoError(t, err) {
+			_, err := ps.Next()
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestParserStateReset(t *testing.T) {
+	p, err := NewParser([]string{"a.b", "c[]", "d"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	records := []string{
+		`{"a":{"b":1,"x":2},"c":[1,2,3],"d":"x"}`,
+		`{"c":[4],"a":{"y":{"b":0},"b":"long value ` + strings.Repeat("-", 100) + `"}}`,
+		`{"a":{"b":1}}}`,
+		`{"d":null}`,
+		`{"a":{"b":1,"x":2},"c":[1,2,3],"d":"x"}`,
+	}
+	ps := p.NewParserState()
+	_, err = ps.Next()
+	assert.Equal(t, ErrAlreadyFinished, err)
+	for i, record := range records {
+		t.Run(fmt.Sprintf("record%d", i), func(t *testing.T) {
+			expected, expectedErr := parseRecord(p.NewParserState(), []byte(record))
+			err := ps.Reset([]byte(record))
+			if expectedErr != nil {
+				assert.Equal(t, expectedErr, err)
+				_, err = ps.Next()
+				assert.Equal(t, ErrAlreadyFinished, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			actual := make([]*KeyValue, 0)
+			for {
+				kv, err := ps.Next()
+				if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+					break
+				}
+				actual = append(actual, kv)
+			}
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestParserStateResetAllocations(t *testing.T) {
+	records := [][]byte{
+		[]byte(`{"id":1,"user":{"id":2,"profile":{"age":3}},"items":[{"name":"a"},{"name":"b"}]}`),
+		[]byte(`{"user":{"profile":{"age":3,"tags":["x"]},"id":2},"id":1,"items":[]}`),
+	}
+	for _, trained := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trained=%v", trained), func(t *testing.T) {
+			// the queried values are found through NextRaw, which reuses the RawKeyValue
+			p, err := NewParser([]string{"id", "user.profile.age", "items[].name", "user.profile.tags[]"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			if trained {
+				if !assert.NoError(t, p.Train(records)) {
+					return
+				}
+			}
+
+			ps := p.NewParserState()
+			found := 0
+			parse := func() {
+				found = 0
+				for _, record := range records {
+					if err := ps.Reset(record); err != nil {
+						t.Fatal(err)
+					}
 					for {
-						kv, err := ps.Next()
-						if assert.NoError(t, err) {
-							if kv.IsEndOfRecord() {
-								break
-							}
-							actual = append(actual, kv)
+						kv, err := ps.NextRaw()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if kv.IsEndOfRecord() {
+							break
 						}
+						found++
 					}
-					assert.Equal(t, tt.expected, actual)
 				}
 			}
+			parse()
+			assert.Equal(t, 0.0, testing.AllocsPerRun(100, parse))
+			assert.Equal(t, 7, found)
 		})
 	}
 }
@@ -0,0 +1,116 @@
+package mison
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const recordReaderBufferSize = 64 * 1024
+
+// RecordReader reads newline-delimited JSON (JSON Lines) records from io.Reader
+type RecordReader struct {
+	p    *Parser
+	r    *bufio.Reader
+	buf  []byte
+	line int
+	ps   *ParserState
+}
+
+// NewRecordReader creates a new RecordReader which parses records in r with p
+func (p *Parser) NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{
+		p:   p,
+		r:   bufio.NewReaderSize(r, recordReaderBufferSize),
+		buf: make([]byte, 0, recordReaderBufferSize),
+		ps:  p.NewParserState(),
+	}
+}
+
+/*
+Next reads the next record and returns a ParserState for it.
+Blank lines are skipped, and io.EOF is returned after the last record.
+
+The buffer of the record and the ParserState are reused, so the returned ParserState is valid until the next call of Next.
+*/
+func (rr *RecordReader) Next() (*ParserState, error) {
+	for {
+		record, err := rr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if skipBlanks(record, 0) == len(record) {
+			continue
+		}
+
+		if err := rr.ps.Reset(record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", rr.line, err)
+		}
+		return rr.ps, nil
+	}
+}
+
+// Line returns the line number of the last record returned by Next
+func (rr *RecordReader) Line() int {
+	return rr.line
+}
+
+/*
+Each calls f for each key/value in the rest of records.
+The KeyValue of JSONEndOfRecord is passed at the end of each record.
+*/
+func (rr *RecordReader) Each(f func(kv *KeyValue) error) error {
+	for {
+		ps, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		for {
+			kv, err := ps.Next()
+			if err != nil {
+				return fmt.Errorf("line %d: %w", rr.line, err)
+			}
+			if err := f(kv); err != nil {
+				return err
+			}
+			if kv.IsEndOfRecord() {
+				break
+			}
+		}
+	}
+}
+
+/*
+readLine reads a line into the buffer without the trailing newline.
+*/
+func (rr *RecordReader) readLine() ([]byte, error) {
+	rr.buf = rr.buf[:0]
+	for {
+		chunk, err := rr.r.ReadSlice('\n')
+		rr.buf = append(rr.buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
+			if len(rr.buf) == 0 {
+				return nil, io.EOF
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		break
+	}
+	rr.line++
+
+	n := len(rr.buf)
+	if n > 0 && rr.buf[n-1] == '\n' {
+		n--
+		if n > 0 && rr.buf[n-1] == '\r' {
+			n--
+		}
+	}
+	return rr.buf[:n], nil
+}
@@ -0,0 +1,136 @@
+package mison
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReader(t *testing.T) {
+	long := strings.Repeat("x", recordReaderBufferSize*2)
+	cases := []struct {
+		input    string
+		expected [][]*KeyValue
+	}{
+		{
+			input: "{\"a\":1}\n{\"a\":2}\n",
+			expected: [][]*KeyValue{
+				{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}},
+				{{FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}},
+			},
+		},
+		{
+			input: "\n{\"a\":1}\r\n  \r\n{\"b\":1}\n{\"a\":true}",
+			expected: [][]*KeyValue{
+				{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}},
+				{},
+				{{FieldID: 0, Value: true, RawValue: "true", Type: JSONBool}},
+			},
+		},
+		{
+			input: `{"b":"` + long + `","a":"` + long + `"}` + "\n" + `{"a":null}`,
+			expected: [][]*KeyValue{
+				{{FieldID: 0, Value: long, RawValue: `"` + long + `"`, Type: JSONString}},
+				{{FieldID: 0, Value: nil, RawValue: "null", Type: JSONNull}},
+			},
+		},
+		{
+			input:    "",
+			expected: [][]*KeyValue{},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d", i), func(t *testing.T) {
+			p, err := NewParser([]string{"a"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			rr := p.NewRecordReader(strings.NewReader(tt.input))
+			actual := make([][]*KeyValue, 0)
+			for {
+				ps, err := rr.Next()
+				if err == io.EOF {
+					break
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				kvs := make([]*KeyValue, 0)
+				for {
+					kv, err := ps.Next()
+					if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+						break
+					}
+					kvs = append(kvs, kv)
+				}
+				actual = append(actual, clearPositions(kvs))
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestRecordReaderEach(t *testing.T) {
+	p, err := NewParser([]string{"a", "b"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rr := p.NewRecordReader(strings.NewReader("{\"a\":1,\"b\":2}\n\n{\"b\":3}\n"))
+	actual := make([]*KeyValue, 0)
+	err = rr.Each(func(kv *KeyValue) error {
+		actual = append(actual, kv)
+		return nil
+	})
+	if assert.NoError(t, err) {
+		eor := &KeyValue{FieldID: -1, Type: JSONEndOfRecord}
+		assert.Equal(t, []*KeyValue{
+			{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber, Start: 5, End: 6, KeyOffset: 1},
+			{FieldID: 1, Value: 2.0, RawValue: "2", Type: JSONNumber, Start: 11, End: 12, KeyOffset: 7},
+			eor,
+			{FieldID: 1, Value: 3.0, RawValue: "3", Type: JSONNumber, Start: 5, End: 6, KeyOffset: 1},
+			eor,
+		}, actual)
+		assert.Equal(t, 3, rr.Line())
+	}
+
+	rr = p.NewRecordReader(strings.NewReader("{\"a\":1}\n{\"a\":1}}\n"))
+	err = rr.Each(func(kv *KeyValue) error { return nil })
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 2:")
+	}
+}
+
+func TestRecordReaderAllocations(t *testing.T) {
+	p, err := NewParser([]string{"user.name", "tags[].y"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	runs := 100
+	rr := p.NewRecordReader(strings.NewReader(strings.Repeat(`{"id":1,"user":{"name":"x"},"tags":[{"y":1},{"y":2}]}`+"\n", runs+1)))
+	found := 0
+	allocs := testing.AllocsPerRun(runs, func() {
+		ps, err := rr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			kv, err := ps.NextRaw()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kv.IsEndOfRecord() {
+				break
+			}
+			found++
+		}
+	})
+	assert.Equal(t, 0.0, allocs)
+	// AllocsPerRun runs the function once more before measuring
+	assert.Equal(t, 3*(runs+1), found)
+}
@@ -0,0 +1,186 @@
+package mison
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+)
+
+// NumberMode represents how to decode numbers
+type NumberMode int
+
+const (
+	// NumberFloat64 decodes numbers into float64
+	NumberFloat64 NumberMode = iota
+	// NumberInt64 decodes integers into int64 and the other numbers into float64, and integers out of int64 are errors
+	NumberInt64
+	// NumberJSONNumber decodes numbers into json.Number, which keeps the literal
+	NumberJSONNumber
+	// NumberBig decodes integers into *big.Int and the other numbers into *big.Float
+	NumberBig
+)
+
+// WithNumberMode sets how to decode numbers (default: NumberFloat64)
+func WithNumberMode(mode NumberMode) ParserOption {
+	return func(p *Parser) {
+		p.literalOptions.numberMode = mode
+	}
+}
+
+/*
+isInteger returns whether the number literal has neither fraction nor exponent part.
+*/
+func isInteger(number []byte) bool {
+	for _, c := range number {
+		if c == '.' || c == 'e' || c == 'E' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+parseInt64 parses the integer literal.
+It returns false when the integer is out of int64.
+*/
+func parseInt64(integer []byte) (int64, bool) {
+	negative := integer[0] == '-'
+	limit := uint64(1<<63 - 1)
+	if negative {
+		integer = integer[1:]
+		limit++
+	}
+	var n uint64
+	for _, c := range integer {
+		d := uint64(c - '0')
+		if n > (limit-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
+	}
+	if negative {
+		return -int64(n), true
+	}
+	return int64(n), true
+}
+
+// float64Pow10 has the powers of 10 which are exactly represented as float64
+var float64Pow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+/*
+parseFloat64Exact parses the number literal without allocations
+when both the digits and the power of 10 are exactly represented as float64,
+so that a single multiplication or division rounds the value correctly.
+It returns false for the other numbers, which should be parsed by strconv.ParseFloat.
+*/
+func parseFloat64Exact(number []byte) (float64, bool) {
+	negative := number[0] == '-'
+	if negative {
+		number = number[1:]
+	}
+
+	var mantissa uint64
+	exp, i := 0, 0
+	fraction := false
+	for ; i < len(number); i++ {
+		c := number[i]
+		if c == '.' {
+			fraction = true
+			continue
+		}
+		if c == 'e' || c == 'E' {
+			break
+		}
+		if mantissa > (1<<53-uint64(c-'0'))/10 {
+			return 0, false
+		}
+		mantissa = mantissa*10 + uint64(c-'0')
+		if fraction {
+			exp--
+		}
+	}
+
+	if i < len(number) {
+		i++
+		expNegative := number[i] == '-'
+		if number[i] == '-' || number[i] == '+' {
+			i++
+		}
+		e := 0
+		for ; i < len(number); i++ {
+			if e > len(float64Pow10) {
+				return 0, false
+			}
+			e = e*10 + int(number[i]-'0')
+		}
+		if expNegative {
+			e = -e
+		}
+		exp += e
+	}
+
+	f := float64(mantissa)
+	switch {
+	case mantissa == 0:
+	case exp >= 0 && exp < len(float64Pow10):
+		f *= float64Pow10[exp]
+	case exp < 0 && -exp < len(float64Pow10):
+		f /= float64Pow10[-exp]
+	default:
+		return 0, false
+	}
+	if negative {
+		f = -f
+	}
+	return f, true
+}
+
+/*
+decodeNumber decodes the number literal in [start, end) of the record in the mode.
+*/
+func decodeNumber(record []byte, start, end int, mode NumberMode) (interface{}, error) {
+	number := record[start:end]
+	switch mode {
+	case NumberInt64:
+		if isInteger(number) {
+			n, ok := parseInt64(number)
+			if !ok {
+				return nil, syntaxErrorAt(record, start, ErrNumberOutOfRange, "number cannot be represented as int64")
+			}
+			return n, nil
+		}
+	case NumberJSONNumber:
+		return json.Number(number), nil
+	case NumberBig:
+		if isInteger(number) {
+			n, _ := new(big.Int).SetString(string(number), 10)
+			return n, nil
+		}
+		// 4 bits per digit keep the precision of the literal
+		f, _, err := big.ParseFloat(string(number), 10, uint(4*len(number)), big.ToNearestEven)
+		if err != nil {
+			return nil, syntaxErrorAt(record, start, ErrNumberOutOfRange, "number cannot be represented as big.Float")
+		}
+		return f, nil
+	}
+
+	v, err := strconv.ParseFloat(string(number), 64)
+	if err != nil {
+		return nil, syntaxErrorAt(record, start, ErrNumberOutOfRange, "number cannot be represented as float64")
+	}
+	return v, nil
+}
+
+/*
+IsInteger returns whether the value is a number without fraction and exponent parts.
+*/
+func (kv *KeyValue) IsInteger() bool {
+	return kv.Type == JSONNumber && isInteger([]byte(kv.RawValue))
+}
+
+/*
+IsInteger returns whether the value is a number without fraction and exponent parts.
+*/
+func (kv *RawKeyValue) IsInteger() bool {
+	return kv.Type == JSONNumber && isInteger(kv.Raw)
+}
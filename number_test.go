@@ -0,0 +1,152 @@
+package mison
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithNumberMode(t *testing.T) {
+	bigFloat := func(s string) *big.Float {
+		f, _, _ := big.ParseFloat(s, 10, uint(4*len(s)), big.ToNearestEven)
+		return f
+	}
+	bigInt := func(s string) *big.Int {
+		n, _ := new(big.Int).SetString(s, 10)
+		return n
+	}
+
+	cases := []struct {
+		mode      NumberMode
+		number    string
+		expected  interface{}
+		isInteger bool
+		err       error
+	}{
+		{mode: NumberFloat64, number: `9007199254740993`, expected: 9007199254740992.0, isInteger: true},
+		{mode: NumberFloat64, number: `-1.5e2`, expected: -150.0},
+		{mode: NumberFloat64, number: `1e400`, err: ErrNumberOutOfRange},
+		{mode: NumberInt64, number: `9007199254740993`, expected: int64(9007199254740993), isInteger: true},
+		{mode: NumberInt64, number: `-9223372036854775808`, expected: int64(-9223372036854775808), isInteger: true},
+		{mode: NumberInt64, number: `9223372036854775808`, err: ErrNumberOutOfRange},
+		{mode: NumberInt64, number: `2.5`, expected: 2.5},
+		{mode: NumberInt64, number: `1E2`, expected: 100.0},
+		{mode: NumberJSONNumber, number: `9007199254740993`, expected: json.Number("9007199254740993"), isInteger: true},
+		{mode: NumberJSONNumber, number: `-0.10`, expected: json.Number("-0.10")},
+		{mode: NumberJSONNumber, number: `1e400`, expected: json.Number("1e400")},
+		{mode: NumberBig, number: `123456789012345678901234567890`, expected: bigInt("123456789012345678901234567890"), isInteger: true},
+		{mode: NumberBig, number: `0.1234567890123456789`, expected: bigFloat("0.1234567890123456789")},
+		{mode: NumberBig, number: `1e400`, expected: bigFloat("1e400")},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %d %s", i, tt.mode, tt.number), func(t *testing.T) {
+			p, err := NewParser([]string{"a"}, WithNumberMode(tt.mode))
+			if !assert.NoError(t, err) {
+				return
+			}
+			ps, err := p.StartParse([]byte(`{"a":` + tt.number + `}`))
+			if !assert.NoError(t, err) {
+				return
+			}
+			kv, err := ps.Next()
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "%v", err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, kv.Value)
+				assert.Equal(t, tt.number, kv.RawValue)
+				assert.Equal(t, JSONNumber, kv.Type)
+				assert.Equal(t, tt.isInteger, kv.IsInteger())
+			}
+		})
+	}
+}
+
+func TestParseFloat64Exact(t *testing.T) {
+	cases := []struct {
+		number string
+		ok     bool
+	}{
+		{number: `0`, ok: true},
+		{number: `-0`, ok: true},
+		{number: `0.0e-400`, ok: false},
+		{number: `12.5`, ok: true},
+		{number: `-1.5E+2`, ok: true},
+		{number: `9007199254740992`, ok: true},
+		{number: `9007199254740993`, ok: false},
+		{number: `0.1`, ok: true},
+		{number: `1e22`, ok: true},
+		{number: `1e23`, ok: false},
+		{number: `1e-22`, ok: true},
+		{number: `1e-23`, ok: false},
+		{number: `123.456e-5`, ok: true},
+		{number: `1e0000000000000000000001`, ok: true},
+		{number: `1e400`, ok: false},
+	}
+
+	for _, tt := range cases {
+		expected, err := strconv.ParseFloat(tt.number, 64)
+		actual, ok := parseFloat64Exact([]byte(tt.number))
+		if assert.Equal(t, tt.ok, ok, tt.number) && ok && assert.NoError(t, err) {
+			assert.Equal(t, math.Float64bits(expected), math.Float64bits(actual), tt.number)
+		}
+	}
+
+	// the fast path must round as strconv does
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		number := fmt.Sprintf("%d.%de%d", r.Int63n(1<<40)-1<<39, r.Int63n(100000), r.Intn(60)-30)
+		expected, err := strconv.ParseFloat(number, 64)
+		if actual, ok := parseFloat64Exact([]byte(number)); ok && assert.NoError(t, err) {
+			assert.Equal(t, math.Float64bits(expected), math.Float64bits(actual), number)
+		}
+	}
+}
+
+func TestIsInteger(t *testing.T) {
+	assert.True(t, (&KeyValue{Type: JSONNumber, RawValue: "-12"}).IsInteger())
+	assert.False(t, (&KeyValue{Type: JSONNumber, RawValue: "1.0"}).IsInteger())
+	assert.False(t, (&KeyValue{Type: JSONNumber, RawValue: "1e2"}).IsInteger())
+	assert.False(t, (&KeyValue{Type: JSONString, RawValue: `"1"`}).IsInteger())
+	assert.True(t, (&RawKeyValue{Type: JSONNumber, Raw: []byte("0")}).IsInteger())
+	assert.False(t, (&RawKeyValue{Type: JSONNumber, Raw: []byte("0.5")}).IsInteger())
+}
+
+func TestDecoderWithNumberMode(t *testing.T) {
+	type record struct {
+		ID    int64       `json:"id"`
+		Count uint16      `json:"count"`
+		Score float64     `json:"score"`
+		Any   interface{} `json:"any"`
+	}
+
+	for _, mode := range []NumberMode{NumberFloat64, NumberInt64, NumberJSONNumber, NumberBig} {
+		t.Run(fmt.Sprintf("mode=%d", mode), func(t *testing.T) {
+			d, err := NewDecoderFor(&record{}, WithNumberMode(mode))
+			if !assert.NoError(t, err) {
+				return
+			}
+			var r record
+			if assert.NoError(t, d.Decode([]byte(`{"id":9007199254740993,"count":1e2,"score":0.5,"any":7}`), &r)) {
+				assert.Equal(t, int64(9007199254740993), r.ID)
+				assert.Equal(t, uint16(100), r.Count)
+				assert.Equal(t, 0.5, r.Score)
+				assert.Equal(t, map[NumberMode]interface{}{
+					NumberFloat64:    7.0,
+					NumberInt64:      int64(7),
+					NumberJSONNumber: json.Number("7"),
+					NumberBig:        big.NewInt(7),
+				}[mode], r.Any)
+			}
+		})
+	}
+}
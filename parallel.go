@@ -0,0 +1,243 @@
+package mison
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// RecordResult is the result of parsing a record
+type RecordResult struct {
+	// Index is the position of the record in the input (starts from 0)
+	Index int
+	// KeyValues are found key/values without the end of record
+	KeyValues []*KeyValue
+	Err       error
+}
+
+type recordJob struct {
+	index  int
+	line   int
+	record []byte
+}
+
+/*
+ParseRecords parses records concurrently with the given number of goroutines
+and returns results in the input order.
+If workers is not positive, GOMAXPROCS is used.
+*/
+func (p *Parser) ParseRecords(records [][]byte, workers int) []RecordResult {
+	jobs := make(chan recordJob)
+	go func() {
+		defer close(jobs)
+		for i, record := range records {
+			jobs <- recordJob{index: i, record: record}
+		}
+	}()
+
+	results := make([]RecordResult, len(records))
+	for r := range p.runWorkers(jobs, workers, false, nil) {
+		results[r.Index] = r
+	}
+	return results
+}
+
+/*
+ParseStream parses records received from the channel concurrently with the given number of goroutines.
+If ordered is true, results are sent in the receiving order, otherwise in the completion order.
+In ordered mode, the records received but not sent yet are limited to a few times workers,
+so a slow record stops receiving later records until its result is sent.
+The returned channel is closed after all records are parsed, so callers must receive all results.
+Records must not be modified until their results are received.
+*/
+func (p *Parser) ParseStream(records <-chan []byte, workers int, ordered bool) <-chan RecordResult {
+	jobs := make(chan recordJob)
+	go func() {
+		defer close(jobs)
+		i := 0
+		for record := range records {
+			jobs <- recordJob{index: i, record: record}
+			i++
+		}
+	}()
+
+	return p.runWorkers(jobs, workers, ordered, nil)
+}
+
+/*
+ParseParallel parses the rest of records concurrently with the given number of goroutines and calls f for each result.
+If ordered is true, f is called in the input order, otherwise in the completion order.
+f is called from a single goroutine, and parsing stops when f returns an error.
+
+ParseParallel returns after the goroutine reading rr exits, so rr can be used again after parsing stops.
+When f returns an error while a line is being read, ParseParallel waits until the read returns.
+*/
+func (rr *RecordReader) ParseParallel(workers int, ordered bool, f func(r *RecordResult) error) error {
+	jobs := make(chan recordJob)
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(readerDone)
+		defer close(jobs)
+		for i := 0; ; {
+			line, err := rr.readLine()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+			if skipBlanks(line, 0) == len(line) {
+				continue
+			}
+
+			job := recordJob{index: i, line: rr.line, record: append([]byte(nil), line...)}
+			select {
+			case jobs <- job:
+			case <-done:
+				return
+			}
+			i++
+		}
+	}()
+
+	var err error
+	for r := range rr.p.runWorkers(jobs, workers, ordered, done) {
+		if err != nil {
+			continue
+		}
+		if err = f(&r); err != nil {
+			close(done)
+		}
+	}
+	<-readerDone
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// pendingRecordsPerWorker is the number of records per worker which can be parsed ahead of the next result in ordered mode
+const pendingRecordsPerWorker = 4
+
+func (p *Parser) runWorkers(jobs <-chan recordJob, workers int, ordered bool, done <-chan struct{}) <-chan RecordResult {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// in ordered mode, each record takes a token until its result is sent,
+	// so that results after a slow record are not buffered without limit
+	var tokens chan struct{}
+	if ordered {
+		tokens = make(chan struct{}, workers*pendingRecordsPerWorker)
+		jobs = limitJobs(jobs, tokens, done)
+	}
+
+	results := make(chan RecordResult, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ps := p.NewParserState()
+			for job := range jobs {
+				r := p.parseJob(ps, job)
+				select {
+				case results <- r:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	if !ordered {
+		return results
+	}
+
+	out := make(chan RecordResult, workers)
+	go reorderResults(results, out, tokens, done)
+	return out
+}
+
+func (p *Parser) parseJob(ps *ParserState, job recordJob) RecordResult {
+	kvs, err := parseRecord(ps, job.record)
+	if err != nil && job.line > 0 {
+		err = fmt.Errorf("line %d: %w", job.line, err)
+	}
+	return RecordResult{Index: job.index, KeyValues: kvs, Err: err}
+}
+
+func parseRecord(ps *ParserState, record []byte) ([]*KeyValue, error) {
+	if err := ps.Reset(record); err != nil {
+		return nil, err
+	}
+
+	kvs := make([]*KeyValue, 0)
+	for {
+		kv, err := ps.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.IsEndOfRecord() {
+			return kvs, nil
+		}
+		kvs = append(kvs, kv)
+	}
+}
+
+/*
+limitJobs forwards jobs after taking a token for each job.
+*/
+func limitJobs(jobs <-chan recordJob, tokens chan<- struct{}, done <-chan struct{}) <-chan recordJob {
+	limited := make(chan recordJob)
+	go func() {
+		defer close(limited)
+		for job := range jobs {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case limited <- job:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return limited
+}
+
+/*
+reorderResults sends results received from in to out in order of the index,
+and releases the token of each result after sending it.
+*/
+func reorderResults(in <-chan RecordResult, out chan<- RecordResult, tokens <-chan struct{}, done <-chan struct{}) {
+	defer close(out)
+	pending := make(map[int]RecordResult)
+	next := 0
+	for r := range in {
+		pending[r.Index] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			select {
+			case out <- r:
+				<-tokens
+			case <-done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package mison
+
+import (
+	"errors"
+	"math/bits"
+	"sync"
+)
+
+// parallelIndexMinSize is the minimum size of records whose structural index is built in parallel
+const parallelIndexMinSize = 1024 * 1024
+
+/*
+indexSegment is a range of words of the structural index built by a goroutine.
+The states at the start of the segment are given by the fix-up passes.
+*/
+type indexSegment struct {
+	from int
+	to   int
+	// whether the segment has an odd number of structual quotes
+	oddQuotes bool
+	inString  bool
+	// the positions of closing characters which are not matched in the segment
+	closers []int
+	// the positions of opening characters which are not closed in the segment
+	openers []int
+	// the first error found in the segment
+	err   error
+	depth int
+}
+
+/*
+buildStructualIndexParallel builds the same structural index as buildStructualIndex with the given number of goroutines.
+
+The words of the bitmaps are split into segments, and each phase runs on the segments in parallel.
+Between the phases, the carries across segments are fixed up:
+the escaping by backslashes is found by looking back the backslashes before the segment,
+whether in a string is found from the parities of quotes in the preceding segments,
+and the depth of nesting is found by matching the unclosed brackets of the preceding segments.
+*/
+func buildStructualIndexParallel(json []byte, level int, levels *indexLevels, workers int) (*structualIndex, error) {
+	jsonLen := len(json)
+	bitmapLen := (jsonLen-1)/wordSize + 1
+	segments := splitIndexSegments(bitmapLen, workers)
+
+	bitmaps := &structualCharacterBitmaps{
+		backslashes: make([]uint64, bitmapLen),
+		quotes:      make([]uint64, bitmapLen),
+		colons:      make([]uint64, bitmapLen),
+		lBraces:     make([]uint64, bitmapLen),
+		rBraces:     make([]uint64, bitmapLen),
+		commas:      make([]uint64, bitmapLen),
+		lBrackets:   make([]uint64, bitmapLen),
+		rBrackets:   make([]uint64, bitmapLen),
+	}
+	runIndexSegments(segments, func(s *indexSegment) {
+		end := s.to * wordSize
+		if end > jsonLen {
+			end = jsonLen
+		}
+		classifyStructualCharacters(json[s.from*wordSize:end], bitmaps.slice(s.from, s.to))
+	})
+
+	stringMaskBitmap := make([]uint64, bitmapLen)
+	runIndexSegments(segments, func(s *indexSegment) {
+		buildSegmentStringMask(bitmaps, stringMaskBitmap, s)
+	})
+	inString := false
+	for _, s := range segments {
+		s.inString = inString
+		inString = inString != s.oddQuotes
+	}
+
+	runIndexSegments(segments, func(s *indexSegment) {
+		matchSegmentBrackets(json, bitmaps, stringMaskBitmap, s)
+	})
+	if err := fixUpDepths(json, segments); err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			syntaxErr.locate(json)
+		}
+		return nil, err
+	}
+
+	colonBitmaps := make([][]uint64, level)
+	commaBitmaps := make([][]uint64, level)
+	for i := 0; i < level; i++ {
+		if levels == nil || levels.colons[i] {
+			colonBitmaps[i] = make([]uint64, bitmapLen)
+		}
+		if levels == nil || levels.commas[i] {
+			commaBitmaps[i] = make([]uint64, bitmapLen)
+		}
+	}
+	runIndexSegments(segments, func(s *indexSegment) {
+		fillSegmentLeveledBitmaps(bitmaps, colonBitmaps, commaBitmaps, s)
+	})
+
+	return &structualIndex{
+		json:                json,
+		level:               level,
+		stringMaskBitmap:    stringMaskBitmap,
+		leveledColonBitmaps: colonBitmaps,
+		leveledCommaBitmaps: commaBitmaps,
+	}, nil
+}
+
+/*
+splitIndexSegments splits bitmapLen words into at most workers segments.
+*/
+func splitIndexSegments(bitmapLen, workers int) []*indexSegment {
+	if workers < 1 {
+		workers = 1
+	}
+	size := (bitmapLen + workers - 1) / workers
+	segments := make([]*indexSegment, 0, workers)
+	for from := 0; from < bitmapLen; from += size {
+		to := from + size
+		if to > bitmapLen {
+			to = bitmapLen
+		}
+		segments = append(segments, &indexSegment{from: from, to: to})
+	}
+	return segments
+}
+
+func runIndexSegments(segments []*indexSegment, f func(s *indexSegment)) {
+	var wg sync.WaitGroup
+	for _, s := range segments {
+		wg.Add(1)
+		go func(s *indexSegment) {
+			defer wg.Done()
+			f(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+/*
+escapeCarryAt returns 1 when the character at word i is escaped by the backslashes before it.
+*/
+func escapeCarryAt(backslashes []uint64, i int) uint64 {
+	run := 0
+	for j := i - 1; j >= 0; j-- {
+		n := bits.LeadingZeros64(^backslashes[j])
+		run += n
+		if n < wordSize {
+			break
+		}
+	}
+	return uint64(run & 1)
+}
+
+/*
+buildSegmentStringMask removes escaped quotes from the quote bitmap of the segment
+and builds the string mask of the segment as if it started out of strings.
+*/
+func buildSegmentStringMask(bitmaps *structualCharacterBitmaps, stringMaskBitmap []uint64, s *indexSegment) {
+	carry := escapeCarryAt(bitmaps.backslashes, s.from)
+	quotes := bitmaps.quotes[s.from:s.to]
+	parity := 0
+	for i := s.from; i < s.to; i++ {
+		quotes[i-s.from] &^= escapedBits(bitmaps.backslashes[i], &carry)
+		parity += popcnt(quotes[i-s.from])
+	}
+	fillStringMaskBitmap(quotes, stringMaskBitmap[s.from:s.to])
+	s.oddQuotes = parity&1 == 1
+}
+
+/*
+matchSegmentBrackets makes structual characters of the segment to be structual,
+and matches the brackets in the segment.
+*/
+func matchSegmentBrackets(json []byte, bitmaps *structualCharacterBitmaps, stringMaskBitmap []uint64, s *indexSegment) {
+	stack := make([]int, 0, stackInitialSize)
+	for i := s.from; i < s.to; i++ {
+		if s.inString {
+			stringMaskBitmap[i] = ^stringMaskBitmap[i]
+		}
+		stringMask := ^stringMaskBitmap[i]
+		bitmaps.colons[i] &= stringMask
+		bitmaps.commas[i] &= stringMask
+		bitmaps.lBraces[i] &= stringMask
+		bitmaps.rBraces[i] &= stringMask
+		bitmaps.lBrackets[i] &= stringMask
+		bitmaps.rBrackets[i] &= stringMask
+		if s.err != nil {
+			continue
+		}
+
+		lefts := bitmaps.lBraces[i] | bitmaps.lBrackets[i]
+		for brackets := lefts | bitmaps.rBraces[i] | bitmaps.rBrackets[i]; brackets != 0; brackets = removeRightmost1(brackets) {
+			bit := extractRightmost1(brackets)
+			position := bitPosition(i, bit)
+			if lefts&bit != 0 {
+				stack = append(stack, position)
+			} else if len(stack) == 0 {
+				s.closers = append(s.closers, position)
+			} else {
+				opener := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if err := matchBracket(json, opener, position); err != nil {
+					s.err = err
+					break
+				}
+			}
+		}
+	}
+	s.openers = stack
+}
+
+func matchBracket(json []byte, opener, closer int) error {
+	if (json[opener] == '{') != (json[closer] == '}') {
+		return newSyntaxError(closer, ErrUnexpectedClosing, "mismatched closing character")
+	}
+	return nil
+}
+
+/*
+fixUpDepths matches the unmatched brackets across segments and sets the depth at the start of each segment.
+The first error in the record is returned.
+*/
+func fixUpDepths(json []byte, segments []*indexSegment) error {
+	stack := make([]int, 0, stackInitialSize)
+	for _, s := range segments {
+		s.depth = len(stack)
+		for _, closer := range s.closers {
+			if len(stack) == 0 {
+				if json[closer] == '}' {
+					return newSyntaxError(closer, ErrUnexpectedClosing, "unexpected right curry blace")
+				}
+				return newSyntaxError(closer, ErrUnexpectedClosing, "unexpected right bracket")
+			}
+			opener := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if err := matchBracket(json, opener, closer); err != nil {
+				return err
+			}
+		}
+		if s.err != nil {
+			return s.err
+		}
+		stack = append(stack, s.openers...)
+	}
+
+	if len(stack) > 0 {
+		return newSyntaxError(stack[len(stack)-1], ErrUnclosed, "unclosed left brace or bracket")
+	}
+	return nil
+}
+
+/*
+fillSegmentLeveledBitmaps sets the colons and commas of the segment to the leveled bitmaps.
+A colon or a comma nested d times is set to the levels from d-1.
+*/
+func fillSegmentLeveledBitmaps(bitmaps *structualCharacterBitmaps, colonBitmaps, commaBitmaps [][]uint64, s *indexSegment) {
+	depth := s.depth
+	for i := s.from; i < s.to; i++ {
+		lefts := bitmaps.lBraces[i] | bitmaps.lBrackets[i]
+		brackets := lefts | bitmaps.rBraces[i] | bitmaps.rBrackets[i]
+		// the positions of the word which are not yet set
+		rest := ^uint64(0)
+		for {
+			bit := extractRightmost1(brackets)
+			mask := rest
+			if bit != 0 {
+				mask &= bit - 1
+			}
+			l := depth - 1
+			if l < 0 {
+				l = 0
+			}
+			for ; l < len(colonBitmaps); l++ {
+				if colonBitmaps[l] != nil {
+					colonBitmaps[l][i] |= bitmaps.colons[i] & mask
+				}
+				if commaBitmaps[l] != nil {
+					commaBitmaps[l][i] |= bitmaps.commas[i] & mask
+				}
+			}
+			if bit == 0 {
+				break
+			}
+			rest &^= mask | bit
+			if lefts&bit != 0 {
+				depth++
+			} else {
+				depth--
+			}
+			brackets = removeRightmost1(brackets)
+		}
+	}
+}
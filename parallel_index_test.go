@@ -0,0 +1,148 @@
+package mison
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+randomJSONValue generates a random JSON value whose strings have escapes and structual characters.
+*/
+func randomJSONValue(r *rand.Rand, b *strings.Builder, depth int) {
+	n := 3
+	if depth > 0 {
+		n = 5
+	}
+	switch r.Intn(n) {
+	case 0:
+		fmt.Fprintf(b, "%d", r.Intn(1000))
+	case 1:
+		b.WriteString(`true`)
+	case 2:
+		b.WriteByte('"')
+		for i := r.Intn(100); i > 0; i-- {
+			b.WriteString([]string{`a`, ` `, `\\`, `\"`, `{`, `}`, `[`, `]`, `:`, `,`, `\\\\`, `\n`}[r.Intn(12)])
+		}
+		b.WriteByte('"')
+	case 3:
+		b.WriteByte('{')
+		for i := r.Intn(5); i >= 0; i-- {
+			fmt.Fprintf(b, `"%c\\" : `, 'a'+r.Intn(3))
+			randomJSONValue(r, b, depth-1)
+			if i > 0 {
+				b.WriteString(", ")
+			}
+		}
+		b.WriteByte('}')
+	case 4:
+		b.WriteByte('[')
+		for i := r.Intn(5); i >= 0; i-- {
+			randomJSONValue(r, b, depth-1)
+			if i > 0 {
+				b.WriteByte(',')
+			}
+		}
+		b.WriteByte(']')
+	}
+}
+
+func TestBuildStructualIndexParallel(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	inputs := [][]byte{[]byte(``), []byte(`{}`), benchmarkRecord()}
+	for i := 0; i < 50; i++ {
+		var b strings.Builder
+		b.WriteByte('{')
+		randomJSONValue(r, &b, 6)
+		b.WriteByte('}')
+		inputs = append(inputs, []byte(`{"a":`+b.String()+`}`))
+	}
+	for _, n := range []int{1, 64, 100, 300, 1000} {
+		for i := 0; i < 20; i++ {
+			inputs = append(inputs, randomJSONLike(r, n))
+		}
+	}
+
+	levels := newIndexLevels(mustBuildQueriedFieldTable(t, []string{"a.b", "a.c[][]"}), 4)
+	for i, json := range inputs {
+		for _, workers := range []int{1, 2, 3, 7, 64} {
+			t.Run(fmt.Sprintf("input%d,workers=%d", i, workers), func(t *testing.T) {
+				for _, levels := range []*indexLevels{nil, levels} {
+					expected, expectedErr := buildStructualIndex(json, 4, levels)
+					actual, actualErr := buildStructualIndexParallel(json, 4, levels, workers)
+					assert.Equal(t, expectedErr, actualErr, "%q", json)
+					assert.Equal(t, expected, actual, "%q", json)
+				}
+			})
+		}
+	}
+}
+
+func mustBuildQueriedFieldTable(t *testing.T, queriedFields []string) queriedFieldTable {
+	table, _, err := buildQueriedFieldTable(queriedFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestParserStateWithParallelIndex(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`{"items":[`)
+	for i := 0; b.Len() < parallelIndexMinSize; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"name":"item \"%d\"","attrs":{"x":[%d]}}`, i, i)
+	}
+	b.WriteString(`],"last":true}`)
+	json := []byte(b.String())
+
+	queriedFields := []string{"items[].attrs.x[]", "last"}
+	p, err := NewParser(queriedFields)
+	if !assert.NoError(t, err) {
+		return
+	}
+	expected := make([]*KeyValue, 0)
+	ps, err := p.StartParse(json)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for {
+		kv, err := ps.Next()
+		if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+			break
+		}
+		expected = append(expected, kv)
+	}
+
+	testParserState(t, json, queriedFields, []ParserOption{WithParallelIndex(4)}, clearPositions(expected))
+}
+
+func BenchmarkBuildStructualIndexParallel(b *testing.B) {
+	json := []byte(strings.Repeat(string(benchmarkRecord())+",", 100))
+	json = []byte(`[` + string(json[:len(json)-1]) + `]`)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(json)))
+			for i := 0; i < b.N; i++ {
+				if _, err := buildStructualIndexParallel(json, 3, nil, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		b.SetBytes(int64(len(json)))
+		for i := 0; i < b.N; i++ {
+			if _, err := buildStructualIndex(json, 3, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
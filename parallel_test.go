@@ -0,0 +1,204 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateRecords(n int) ([][]byte, []RecordResult) {
+	records := make([][]byte, n)
+	expected := make([]RecordResult, n)
+	for i := 0; i < n; i++ {
+		if i%10 == 9 {
+			records[i] = []byte(fmt.Sprintf(`{"a":%d}}`, i))
+			expected[i] = RecordResult{Index: i}
+			continue
+		}
+		records[i] = []byte(fmt.Sprintf(`{"b":{"c":"%s"},"a":%d}`, strings.Repeat("x", i%50), i))
+		key := len(`{"b":{"c":""},`) + i%50
+		kv := &KeyValue{FieldID: 0, Value: float64(i), RawValue: fmt.Sprint(i), Type: JSONNumber, Start: key + 4, End: key + 4 + len(fmt.Sprint(i)), KeyOffset: key}
+		expected[i] = RecordResult{Index: i, KeyValues: []*KeyValue{kv}}
+	}
+	return records, expected
+}
+
+func assertRecordResults(t *testing.T, expected, actual []RecordResult) {
+	if assert.Equal(t, len(expected), len(actual)) {
+		for i := range expected {
+			assert.Equal(t, expected[i].Index, actual[i].Index)
+			if expected[i].KeyValues == nil {
+				assert.Error(t, actual[i].Err)
+			} else {
+				assert.NoError(t, actual[i].Err)
+				assert.Equal(t, expected[i].KeyValues, actual[i].KeyValues)
+			}
+		}
+	}
+}
+
+func TestParseRecords(t *testing.T) {
+	p, err := NewParser([]string{"a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, workers := range []int{0, 1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			records, expected := generateRecords(200)
+			assertRecordResults(t, expected, p.ParseRecords(records, workers))
+		})
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	p, err := NewParser([]string{"a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, ordered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			records, expected := generateRecords(200)
+			in := make(chan []byte)
+			go func() {
+				defer close(in)
+				for _, record := range records {
+					in <- record
+				}
+			}()
+
+			actual := make([]RecordResult, 0)
+			for r := range p.ParseStream(in, 4, ordered) {
+				actual = append(actual, r)
+			}
+			if !ordered {
+				sort.Slice(actual, func(i, j int) bool { return actual[i].Index < actual[j].Index })
+			}
+			assertRecordResults(t, expected, actual)
+		})
+	}
+}
+
+func TestParseStreamOrderedPending(t *testing.T) {
+	p, err := NewParser([]string{"a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the first record is slow to parse, and the others are fast
+	workers, n := 2, 10000
+	slow := []byte(`{"b":"` + strings.Repeat("x", 1<<24) + `","a":0}`)
+	var received int64
+	in := make(chan []byte)
+	go func() {
+		defer close(in)
+		in <- slow
+		for i := 1; i < n; i++ {
+			in <- []byte(fmt.Sprintf(`{"a":%d}`, i))
+			atomic.AddInt64(&received, 1)
+		}
+	}()
+
+	i := 0
+	for r := range p.ParseStream(in, workers, true) {
+		if i == 0 {
+			// the tokens, the results sent to the buffer, and the records held by goroutines
+			bound := workers*pendingRecordsPerWorker + (workers + 1) + 2
+			assert.True(t, atomic.LoadInt64(&received) <= int64(bound), "%d records are received", atomic.LoadInt64(&received))
+		}
+		if assert.NoError(t, r.Err) && assert.Equal(t, i, r.Index) && assert.Len(t, r.KeyValues, 1) {
+			assert.Equal(t, float64(i), r.KeyValues[0].Value)
+		}
+		i++
+	}
+	assert.Equal(t, n, i)
+}
+
+func TestParseParallel(t *testing.T) {
+	p, err := NewParser([]string{"a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	records, expected := generateRecords(200)
+	input := ""
+	for _, record := range records {
+		input += string(record) + "\n\n"
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		actual := make([]RecordResult, 0)
+		err := p.NewRecordReader(strings.NewReader(input)).ParseParallel(4, true, func(r *RecordResult) error {
+			actual = append(actual, *r)
+			return nil
+		})
+		if assert.NoError(t, err) {
+			assertRecordResults(t, expected, actual)
+			assert.Contains(t, actual[9].Err.Error(), "line 19:")
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		stop := errors.New("stop")
+		n := 0
+		err := p.NewRecordReader(strings.NewReader(input)).ParseParallel(4, false, func(r *RecordResult) error {
+			n++
+			if n == 10 {
+				return stop
+			}
+			return nil
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 10, n)
+
+		// stop while the reader is blocked
+		r := &blockingReader{r: strings.NewReader(strings.Join(strings.SplitN(input, "\n\n", 11)[:10], "\n")), release: make(chan struct{})}
+		rr := p.NewRecordReader(r)
+		returned := make(chan error)
+		go func() {
+			n := 0
+			returned <- rr.ParseParallel(4, false, func(r *RecordResult) error {
+				if n++; n == 10 {
+					return stop
+				}
+				return nil
+			})
+		}()
+
+		select {
+		case <-returned:
+			t.Fatal("ParseParallel returned while the reader is blocked")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(r.release)
+		assert.Equal(t, stop, <-returned)
+
+		// the reader goroutine has exited, so rr is not read concurrently
+		_, err = rr.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+/*
+blockingReader reads r, and then blocks until release is closed before io.EOF.
+*/
+type blockingReader struct {
+	r       io.Reader
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err == io.EOF {
+		<-r.release
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package mison
+
+import "sort"
+
+/*
+patternStep represents a queried field found in an object with the ordinal of its colon.
+*/
+type patternStep struct {
+	ordinal int
+	name    string
+}
+
+/*
+patternNode is a node of patternTree.
+The path from the root to the node represents a sequence of queried fields found in an object.
+*/
+type patternNode struct {
+	step     patternStep
+	count    int
+	complete bool
+	// the numbers of colons in the objects of the complete pattern
+	colonCounts []int
+	children    []*patternNode
+}
+
+/*
+patternTree collects the positions of queried fields observed in training records.
+Children of each node are sorted by frequency in descending order.
+
+See section 5.
+*/
+type patternTree struct {
+	root patternNode
+}
+
+/*
+insert adds the pattern found in an object which has the number of colons.
+complete means that the pattern contains all queried fields of the object.
+*/
+func (t *patternTree) insert(steps []patternStep, complete bool, colons int) {
+	node := &t.root
+	node.count++
+	for _, step := range steps {
+		var child *patternNode
+		for _, c := range node.children {
+			if c.step == step {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &patternNode{step: step}
+			node.children = append(node.children, child)
+		}
+		child.count++
+		sort.SliceStable(node.children, func(i, j int) bool {
+			return node.children[i].count > node.children[j].count
+		})
+		node = child
+	}
+	if complete {
+		node.complete = true
+		for _, c := range node.colonCounts {
+			if c == colons {
+				return
+			}
+		}
+		node.colonCounts = append(node.colonCounts, colons)
+	}
+}
+
+/*
+speculate searches a complete pattern whose every step is accepted by verify
+and which is observed in objects with the same number of colons.
+Patterns are tried in order of frequency, and the steps of the found pattern are appended to steps.
+*/
+func (t *patternTree) speculate(steps []patternStep, colons int, verify func(step patternStep) bool) ([]patternStep, bool) {
+	return t.root.speculate(steps, colons, verify)
+}
+
+func (n *patternNode) speculate(steps []patternStep, colons int, verify func(step patternStep) bool) ([]patternStep, bool) {
+	if n.complete {
+		for _, c := range n.colonCounts {
+			if c == colons {
+				return steps, true
+			}
+		}
+	}
+	for _, child := range n.children {
+		if !verify(child.step) {
+			continue
+		}
+		if found, ok := child.speculate(append(steps, child.step), colons, verify); ok {
+			return found, true
+		}
+	}
+	return nil, false
+}
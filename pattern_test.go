@@ -0,0 +1,183 @@
+package mison
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternTree(t *testing.T) {
+	tree := &patternTree{}
+	tree.insert([]patternStep{{1, "a"}, {3, "b"}}, true, 4)
+	tree.insert([]patternStep{{0, "a"}, {2, "b"}}, true, 4)
+	tree.insert([]patternStep{{0, "a"}, {2, "b"}}, true, 5)
+	tree.insert([]patternStep{{0, "a"}}, false, 1)
+
+	cases := []struct {
+		fields   []string
+		expected []patternStep
+		ok       bool
+	}{
+		{
+			fields:   []string{"a", "x", "b", "y"},
+			expected: []patternStep{{0, "a"}, {2, "b"}},
+			ok:       true,
+		},
+		{
+			fields:   []string{"x", "a", "y", "b"},
+			expected: []patternStep{{1, "a"}, {3, "b"}},
+			ok:       true,
+		},
+		{
+			fields: []string{"a", "x", "y", "b"},
+			ok:     false,
+		},
+		{
+			fields:   []string{"a", "x", "b", "y", "z"},
+			expected: []patternStep{{0, "a"}, {2, "b"}},
+			ok:       true,
+		},
+		{
+			fields: []string{"a", "x", "b"},
+			ok:     false,
+		},
+		{
+			fields: []string{"a", "x", "b", "y", "z", "a"},
+			ok:     false,
+		},
+		{
+			fields: []string{"a"},
+			ok:     false,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %q", i, tt.fields), func(t *testing.T) {
+			verify := func(step patternStep) bool {
+				return step.ordinal < len(tt.fields) && tt.fields[step.ordinal] == step.name
+			}
+			actual, ok := tree.speculate(nil, len(tt.fields), verify)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTrain(t *testing.T) {
+	queriedFields := []string{"id", "user.name", "items[].price"}
+	training := [][]byte{
+		[]byte(`{"id":1,"user":{"id":2,"name":"a"},"items":[{"price":1}]}`),
+		[]byte(`{"id":2,"user":{"id":3,"name":"b"},"items":[]}`),
+		[]byte(`{"id":3,"user":{"id":4,"name":"c"},"items":[{"name":"x","price":2}]}`),
+	}
+	cases := []struct {
+		json        []byte
+		speculative bool
+	}{
+		{
+			json:        []byte(`{"id":4,"user":{"id":5,"name":"d"},"items":[{"price":3},{"price":4}]}`),
+			speculative: true,
+		},
+		{
+			json:        []byte(`{"user":{"name":"e","id":6},"id":5,"extra":true,"items":[{"price":5,"name":"y"}]}`),
+			speculative: false,
+		},
+		{
+			json:        []byte(`{"id":6,"user":{"id":7},"items":null}`),
+			speculative: true,
+		},
+	}
+
+	collect := func(p *Parser, json []byte) ([]*KeyValue, *ParserState) {
+		ps, err := p.StartParse(json)
+		if !assert.NoError(t, err) {
+			return nil, nil
+		}
+		kvs := make([]*KeyValue, 0)
+		for {
+			kv, err := ps.Next()
+			if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+				break
+			}
+			kvs = append(kvs, kv)
+		}
+		return kvs, ps
+	}
+
+	plain, err := NewParser(queriedFields)
+	if !assert.NoError(t, err) {
+		return
+	}
+	trained, err := NewParser(queriedFields)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, trained.Train(training)) {
+		return
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			expected, _ := collect(plain, tt.json)
+			ps, err := trained.StartParse(tt.json)
+			if assert.NoError(t, err) {
+				kv, err := ps.Next()
+				if assert.NoError(t, err) && assert.NotEmpty(t, expected) {
+					assert.Equal(t, expected[0], kv)
+					assert.Equal(t, tt.speculative, ps.stack[0].names != nil)
+				}
+			}
+			actual, _ := collect(trained, tt.json)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestTrainDuplicatedFields(t *testing.T) {
+	queriedFields := []string{"a", "c"}
+	training := [][]byte{[]byte(`{"a":0,"b":0,"c":0}`)}
+	plain, err := NewParser(queriedFields)
+	if !assert.NoError(t, err) {
+		return
+	}
+	trained, err := NewParser(queriedFields)
+	if !assert.NoError(t, err) || !assert.NoError(t, trained.Train(training)) {
+		return
+	}
+
+	collect := func(p *Parser, json []byte) []string {
+		kvs, err := parseRecord(p.NewParserState(), json)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		values := make([]string, len(kvs))
+		for i, kv := range kvs {
+			values[i] = kv.RawValue
+		}
+		return values
+	}
+
+	// unique field names
+	json := []byte(`{"a":1,"b":2,"c":3}`)
+	assert.Equal(t, collect(plain, json), collect(trained, json))
+
+	// the duplicated fields change the number of colons, so the full scan returns them
+	for _, record := range []string{`{"a":1,"b":0,"a":2,"c":3}`, `{"a":1,"a":2,"b":0,"c":3}`, `{"a":1,"b":0,"b":0,"a":2,"c":3}`} {
+		json = []byte(record)
+		assert.Equal(t, []string{"1", "2", "3"}, collect(plain, json), record)
+		assert.Equal(t, collect(plain, json), collect(trained, json), record)
+	}
+
+	// the duplicated field replaces the unqueried field of the pattern
+	json = []byte(`{"a":1,"a":2,"c":3}`)
+	assert.Equal(t, []string{"1", "2", "3"}, collect(plain, json))
+	assert.Equal(t, []string{"1", "3"}, collect(trained, json))
+
+	// the speculation fails, so the full scan returns the duplicated field
+	json = []byte(`{"a":1,"c":2,"a":3,"c":4}`)
+	assert.Equal(t, []string{"1", "2"}, collect(plain, json))
+	assert.Equal(t, collect(plain, json), collect(trained, json))
+}
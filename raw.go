@@ -0,0 +1,145 @@
+package mison
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+/*
+RawKeyValue represents found key-value in JSON whose value is not decoded yet.
+Raw refers the record, and the value is decoded by the accessors on demand,
+so key/values which are only compared or forwarded as bytes are parsed without allocations.
+*/
+type RawKeyValue struct {
+	FieldID int
+	Type    JSONType
+	// Raw is the raw value in the record, which is the whole object or array for JSONObject and JSONArray
+	Raw []byte
+
+	json    []byte
+	literal literal
+	options literalOptions
+}
+
+// IsEndOfRecord check end of record
+func (kv *RawKeyValue) IsEndOfRecord() bool {
+	return kv.Type == JSONEndOfRecord
+}
+
+func (kv *RawKeyValue) typeError(expected JSONType) error {
+	return fmt.Errorf("%w: %s is accessed as %s", ErrTypeMismatch, kv.Type, expected)
+}
+
+// Bool returns the value of the bool
+func (kv *RawKeyValue) Bool() (bool, error) {
+	if kv.Type != JSONBool {
+		return false, kv.typeError(JSONBool)
+	}
+	return kv.Raw[0] == 't', nil
+}
+
+/*
+Float64 returns the value of the number as float64.
+Numbers of at most 15 digits with small exponents are parsed without allocations.
+*/
+func (kv *RawKeyValue) Float64() (float64, error) {
+	if kv.Type != JSONNumber {
+		return 0, kv.typeError(JSONNumber)
+	}
+	if v, ok := parseFloat64Exact(kv.Raw); ok {
+		return v, nil
+	}
+	v, err := strconv.ParseFloat(string(kv.Raw), 64)
+	if err != nil {
+		return 0, syntaxErrorAt(kv.json, kv.literal.start, ErrNumberOutOfRange, "number cannot be represented as float64")
+	}
+	return v, nil
+}
+
+/*
+Int64 returns the value of the number as int64.
+Numbers with fraction or exponent parts are not accepted even if they are integers.
+*/
+func (kv *RawKeyValue) Int64() (int64, error) {
+	if kv.Type != JSONNumber {
+		return 0, kv.typeError(JSONNumber)
+	}
+
+	if !isInteger(kv.Raw) {
+		return 0, fmt.Errorf("%w: number %s is not an integer", ErrTypeMismatch, kv.Raw)
+	}
+	n, ok := parseInt64(kv.Raw)
+	if !ok {
+		return 0, syntaxErrorAt(kv.json, kv.literal.start, ErrNumberOutOfRange, "number cannot be represented as int64")
+	}
+	return n, nil
+}
+
+/*
+Str returns the decoded value of the string.
+Escape sequences are validated at this time, so invalid strings are not reported by ParserState.NextRaw.
+*/
+func (kv *RawKeyValue) Str() (string, error) {
+	if kv.Type != JSONString {
+		return "", kv.typeError(JSONString)
+	}
+	v, err := kv.literal.decode(kv.json, kv.options)
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+/*
+AppendStr appends the decoded value of the string to buf.
+*/
+func (kv *RawKeyValue) AppendStr(buf []byte) ([]byte, error) {
+	if kv.Type != JSONString {
+		return buf, kv.typeError(JSONString)
+	}
+	body := kv.Raw[1 : len(kv.Raw)-1]
+	if !kv.literal.escaped {
+		return append(buf, body...), nil
+	}
+	decoded, err := appendUnescaped(buf, body, kv.options)
+	if err != nil {
+		return buf, stringSyntaxError(kv.json, kv.literal.start+1, err)
+	}
+	return decoded, nil
+}
+
+/*
+copyRawMessage returns the copy of the raw object or array as the value of KeyValue.
+*/
+func copyRawMessage(raw []byte) json.RawMessage {
+	return append(json.RawMessage(nil), raw...)
+}
+
+/*
+NextRaw returns next key/value without decoding the value.
+The returned RawKeyValue is reused by the next call of NextRaw, and Raw is valid while the record is not modified.
+The end of record is returned like Next.
+*/
+func (ps *ParserState) NextRaw() (*RawKeyValue, error) {
+	found, err := ps.advance()
+	if err != nil {
+		return nil, err
+	}
+	kv := &ps.rawKV
+	if !found {
+		*kv = RawKeyValue{FieldID: -1, Type: JSONEndOfRecord}
+		return kv, nil
+	}
+
+	json := ps.index.json
+	*kv = RawKeyValue{
+		FieldID: ps.entry.id,
+		Type:    ps.value.t,
+		Raw:     json[ps.value.start:ps.value.end],
+		json:    json,
+		literal: ps.value,
+		options: ps.p.literalOptions,
+	}
+	return kv, nil
+}
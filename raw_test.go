@@ -0,0 +1,263 @@
+package mison
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectRawKeyValues(t *testing.T, p *Parser, json []byte) []RawKeyValue {
+	ps, err := p.StartParse(json)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	kvs := make([]RawKeyValue, 0)
+	for {
+		kv, err := ps.NextRaw()
+		if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+			return kvs
+		}
+		kvs = append(kvs, *kv)
+	}
+}
+
+func TestParserStateNextRaw(t *testing.T) {
+	p, err := NewParser([]string{"a", "b[]", "c.d"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	json := []byte(`{"b":[1, "x\ty", true, null], "a": -12.5e1 ,"c":{"d":"é\"\\"}}`)
+	kvs := collectRawKeyValues(t, p, json)
+	if !assert.Len(t, kvs, 6) {
+		return
+	}
+	expected := []struct {
+		fieldID  int
+		jsonType JSONType
+		raw      string
+	}{
+		{1, JSONNumber, `1`},
+		{1, JSONString, `"x\ty"`},
+		{1, JSONBool, `true`},
+		{1, JSONNull, `null`},
+		{0, JSONNumber, `-12.5e1`},
+		{2, JSONString, `"é\"\\"`},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.fieldID, kvs[i].FieldID)
+		assert.Equal(t, e.jsonType, kvs[i].Type)
+		assert.Equal(t, e.raw, string(kvs[i].Raw))
+	}
+
+	// Raw refers the record
+	assert.True(t, &kvs[0].Raw[0] == &json[6])
+
+	v, err := kvs[0].Int64()
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), v)
+	}
+	s, err := kvs[1].Str()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "x\ty", s)
+	}
+	b, err := kvs[2].Bool()
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, b)
+	}
+	f, err := kvs[4].Float64()
+	if assert.NoError(t, err) {
+		assert.Equal(t, -125.0, f)
+	}
+	buf, err := kvs[5].AppendStr([]byte("prefix:"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "prefix:é\"\\", string(buf))
+	}
+}
+
+func TestRawKeyValueAccessors(t *testing.T) {
+	cases := []struct {
+		value    string
+		accessor string
+		expected interface{}
+		err      error
+	}{
+		{value: `0`, accessor: "Int64", expected: int64(0)},
+		{value: `-0`, accessor: "Int64", expected: int64(0)},
+		{value: `9223372036854775807`, accessor: "Int64", expected: int64(math.MaxInt64)},
+		{value: `-9223372036854775808`, accessor: "Int64", expected: int64(math.MinInt64)},
+		{value: `9223372036854775808`, accessor: "Int64", err: ErrNumberOutOfRange},
+		{value: `-9223372036854775809`, accessor: "Int64", err: ErrNumberOutOfRange},
+		{value: `1.0`, accessor: "Int64", err: ErrTypeMismatch},
+		{value: `1e2`, accessor: "Int64", err: ErrTypeMismatch},
+		{value: `"1"`, accessor: "Int64", err: ErrTypeMismatch},
+		{value: `1e400`, accessor: "Float64", err: ErrNumberOutOfRange},
+		{value: `true`, accessor: "Float64", err: ErrTypeMismatch},
+		{value: `false`, accessor: "Bool", expected: false},
+		{value: `null`, accessor: "Bool", err: ErrTypeMismatch},
+		{value: `""`, accessor: "Str", expected: ""},
+		{value: `"😀"`, accessor: "Str", expected: "😀"},
+		{value: `"\x"`, accessor: "Str", err: ErrInvalidString},
+		{value: `"\x"`, accessor: "AppendStr", err: ErrInvalidString},
+		{value: `1`, accessor: "Str", err: ErrTypeMismatch},
+		{value: `{"b":1}`, accessor: "Str", err: ErrTypeMismatch},
+		{value: `[1]`, accessor: "Float64", err: ErrTypeMismatch},
+	}
+
+	p, err := NewParser([]string{"a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s.%s", i, tt.value, tt.accessor), func(t *testing.T) {
+			kvs := collectRawKeyValues(t, p, []byte(`{"a":`+tt.value+`}`))
+			if !assert.Len(t, kvs, 1) {
+				return
+			}
+			kv := kvs[0]
+			var actual interface{}
+			var err error
+			switch tt.accessor {
+			case "Int64":
+				actual, err = kv.Int64()
+			case "Float64":
+				actual, err = kv.Float64()
+			case "Bool":
+				actual, err = kv.Bool()
+			case "Str":
+				actual, err = kv.Str()
+			case "AppendStr":
+				var buf []byte
+				buf, err = kv.AppendStr(nil)
+				actual = string(buf)
+			}
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "%v", err)
+				var syntaxErr *SyntaxError
+				if errors.As(err, &syntaxErr) {
+					assert.Equal(t, 1, syntaxErr.Line)
+				}
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParserStateNextRawMatchesNext(t *testing.T) {
+	p, err := NewParser([]string{"id", "items[].name", "items[].price", "items[].attrs.x", "items[].attrs.y", "payload"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	json := benchmarkRecord()
+	expected, err := parseRecord(p.NewParserState(), json)
+	if !assert.NoError(t, err) {
+		return
+	}
+	expected = clearPositions(expected)
+
+	actual := make([]*KeyValue, 0)
+	for _, kv := range collectRawKeyValues(t, p, json) {
+		var v interface{}
+		var err error
+		switch kv.Type {
+		case JSONNumber:
+			v, err = kv.Float64()
+		case JSONString:
+			v, err = kv.Str()
+		case JSONBool:
+			v, err = kv.Bool()
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		actual = append(actual, &KeyValue{FieldID: kv.FieldID, Type: kv.Type, Value: v, RawValue: string(kv.Raw)})
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestParserStateNextRawAllocations(t *testing.T) {
+	p, err := NewParser([]string{"id", "items[].price", "items[].attrs.x", "items[].name"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	json := benchmarkRecord()
+	ps := p.NewParserState()
+	buf := make([]byte, 0, 64)
+	parse := func() {
+		if err := ps.Reset(json); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			kv, err := ps.NextRaw()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch kv.Type {
+			case JSONEndOfRecord:
+				return
+			case JSONNumber:
+				_, err = kv.Float64()
+			case JSONBool:
+				_, err = kv.Bool()
+			case JSONString:
+				buf, err = kv.AppendStr(buf[:0])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	parse()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, parse))
+}
+
+func BenchmarkParserStateNext(b *testing.B) {
+	p, err := NewParser([]string{"id", "items[].price", "items[].attrs.x", "items[].name"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	json := benchmarkRecord()
+	ps := p.NewParserState()
+
+	b.Run("Next", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(json)))
+		for i := 0; i < b.N; i++ {
+			if err := ps.Reset(json); err != nil {
+				b.Fatal(err)
+			}
+			for {
+				kv, err := ps.Next()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if kv.IsEndOfRecord() {
+					break
+				}
+			}
+		}
+	})
+
+	b.Run("NextRaw", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(json)))
+		for i := 0; i < b.N; i++ {
+			if err := ps.Reset(json); err != nil {
+				b.Fatal(err)
+			}
+			for {
+				kv, err := ps.NextRaw()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if kv.IsEndOfRecord() {
+					break
+				}
+			}
+		}
+	})
+}
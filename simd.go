@@ -0,0 +1,7 @@
+package mison
+
+/*
+useSIMD enables the SIMD implementations of building the structural index when the CPU supports them.
+The portable implementations are used on other architectures or with the purego build tag.
+*/
+var useSIMD = true
@@ -0,0 +1,81 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+package mison
+
+var (
+	hasAVX2   = detectAVX2()
+	hasPCLMUL = detectPCLMUL()
+)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+//go:noescape
+func classifyBlocksAVX2(src *byte, blocks int, dst *[8]*uint64)
+
+//go:noescape
+func stringMaskCLMUL(quotes *uint64, n int, dst *uint64)
+
+/*
+osSupportsAVX returns whether the OS saves the states of XMM and YMM registers.
+*/
+func osSupportsAVX() bool {
+	_, _, ecx, _ := cpuid(1, 0)
+	const osxsave = 1 << 27
+	const avx = 1 << 28
+	if ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+	eax, _ := xgetbv()
+	return eax&0x6 == 0x6
+}
+
+func detectAVX2() bool {
+	if maxID, _, _, _ := cpuid(0, 0); maxID < 7 || !osSupportsAVX() {
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	return ebx&(1<<5) != 0
+}
+
+func detectPCLMUL() bool {
+	_, _, ecx, _ := cpuid(1, 0)
+	return ecx&(1<<1) != 0
+}
+
+/*
+classifyStructualCharactersSIMD builds the character bitmaps of the full 64 byte blocks of json with AVX2.
+It returns the number of built words, which is 0 when AVX2 is not available.
+*/
+func classifyStructualCharactersSIMD(json []byte, bitmaps *structualCharacterBitmaps) int {
+	blocks := len(json) / wordSize
+	if !useSIMD || !hasAVX2 || blocks == 0 {
+		return 0
+	}
+	dst := [8]*uint64{
+		&bitmaps.backslashes[0],
+		&bitmaps.quotes[0],
+		&bitmaps.colons[0],
+		&bitmaps.lBraces[0],
+		&bitmaps.rBraces[0],
+		&bitmaps.commas[0],
+		&bitmaps.lBrackets[0],
+		&bitmaps.rBrackets[0],
+	}
+	classifyBlocksAVX2(&json[0], blocks, &dst)
+	return blocks
+}
+
+/*
+buildStringMaskBitmapSIMD builds the string mask bitmap into stringMask with PCLMULQDQ.
+It returns false when PCLMULQDQ is not available.
+*/
+func buildStringMaskBitmapSIMD(quoteBitmaps, stringMask []uint64) bool {
+	if !useSIMD || !hasPCLMUL || len(quoteBitmaps) == 0 {
+		return false
+	}
+	stringMaskCLMUL(&quoteBitmaps[0], len(quoteBitmaps), &stringMask[0])
+	return true
+}
@@ -0,0 +1,115 @@
+//go:build amd64 && !purego
+// +build amd64,!purego
+
+#include "textflag.h"
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+#define BROADCAST(c, y) \
+	MOVQ c, AX; \
+	MOVQ AX, X2; \
+	VPBROADCASTQ X2, y
+
+// CLASSIFY stores the bitmap of bytes in Y0:Y1 which are equal to c into ptr[DI]
+#define CLASSIFY(c, ptr) \
+	VPCMPEQB Y0, c, Y2; \
+	VPMOVMSKB Y2, AX; \
+	VPCMPEQB Y1, c, Y3; \
+	VPMOVMSKB Y3, DX; \
+	SHLQ $32, DX; \
+	ORQ DX, AX; \
+	MOVQ AX, (ptr)(DI*8)
+
+// func classifyBlocksAVX2(src *byte, blocks int, dst *[8]*uint64)
+TEXT ·classifyBlocksAVX2(SB), NOSPLIT, $0-24
+	MOVQ src+0(FP), SI
+	MOVQ blocks+8(FP), CX
+	MOVQ dst+16(FP), DI
+	MOVQ 0(DI), BX
+	MOVQ 8(DI), R8
+	MOVQ 16(DI), R9
+	MOVQ 24(DI), R10
+	MOVQ 32(DI), R11
+	MOVQ 40(DI), R12
+	MOVQ 48(DI), R13
+	MOVQ 56(DI), R14
+
+	BROADCAST($0x5c5c5c5c5c5c5c5c, Y8)  // '\\'
+	BROADCAST($0x2222222222222222, Y9)  // '"'
+	BROADCAST($0x3a3a3a3a3a3a3a3a, Y10) // ':'
+	BROADCAST($0x7b7b7b7b7b7b7b7b, Y11) // '{'
+	BROADCAST($0x7d7d7d7d7d7d7d7d, Y12) // '}'
+	BROADCAST($0x2c2c2c2c2c2c2c2c, Y13) // ','
+	BROADCAST($0x5b5b5b5b5b5b5b5b, Y14) // '['
+	BROADCAST($0x5d5d5d5d5d5d5d5d, Y15) // ']'
+
+	XORQ DI, DI
+	TESTQ CX, CX
+	JZ   done
+
+loop:
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	CLASSIFY(Y8, BX)
+	CLASSIFY(Y9, R8)
+	CLASSIFY(Y10, R9)
+	CLASSIFY(Y11, R10)
+	CLASSIFY(Y12, R11)
+	CLASSIFY(Y13, R12)
+	CLASSIFY(Y14, R13)
+	CLASSIFY(Y15, R14)
+	ADDQ $64, SI
+	INCQ DI
+	CMPQ DI, CX
+	JB   loop
+
+done:
+	VZEROUPPER
+	RET
+
+// func stringMaskCLMUL(quotes *uint64, n int, dst *uint64)
+TEXT ·stringMaskCLMUL(SB), NOSPLIT, $0-24
+	MOVQ quotes+0(FP), SI
+	MOVQ n+8(FP), CX
+	MOVQ dst+16(FP), DI
+	MOVQ $-1, AX
+	MOVQ AX, X1
+	XORQ R8, R8 // all ones while in string
+	XORQ BX, BX
+	TESTQ CX, CX
+	JZ   clmuldone
+
+clmulloop:
+	MOVQ (SI)(BX*8), DX
+	MOVQ DX, X0
+	PCLMULQDQ $0x00, X1, X0
+	MOVQ X0, AX
+	XORQ R8, AX
+	// the carry is from the inclusive prefix XOR, and the mask excludes the quotes themselves
+	MOVQ AX, R8
+	XORQ DX, AX
+	MOVQ AX, (DI)(BX*8)
+	SARQ $63, R8
+	INCQ BX
+	CMPQ BX, CX
+	JB   clmulloop
+
+clmuldone:
+	RET
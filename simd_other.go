@@ -0,0 +1,17 @@
+//go:build !amd64 || purego
+// +build !amd64 purego
+
+package mison
+
+const (
+	hasAVX2   = false
+	hasPCLMUL = false
+)
+
+func classifyStructualCharactersSIMD(json []byte, bitmaps *structualCharacterBitmaps) int {
+	return 0
+}
+
+func buildStringMaskBitmapSIMD(quoteBitmaps, stringMask []uint64) bool {
+	return false
+}
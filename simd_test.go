@@ -0,0 +1,56 @@
+package mison
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withoutSIMD(f func()) {
+	useSIMD = false
+	defer func() { useSIMD = true }()
+	f()
+}
+
+func randomJSONLike(r *rand.Rand, n int) []byte {
+	alphabet := []byte(`\\\\\"":{},[]  ab01`)
+	json := make([]byte, n)
+	for i := range json {
+		if r.Intn(8) == 0 {
+			json[i] = byte(r.Intn(256))
+		} else {
+			json[i] = alphabet[r.Intn(len(alphabet))]
+		}
+	}
+	return json
+}
+
+func TestSIMDMatchesPortable(t *testing.T) {
+	if !hasAVX2 && !hasPCLMUL {
+		t.Skip("SIMD is not available")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 63, 64, 65, 127, 128, 200, 1000} {
+		for trial := 0; trial < 20; trial++ {
+			json := randomJSONLike(r, n)
+			t.Run(fmt.Sprintf("n=%d,trial=%d", n, trial), func(t *testing.T) {
+				var expected *structualCharacterBitmaps
+				withoutSIMD(func() { expected = buildStructualCharacterBitmaps(json) })
+				actual := buildStructualCharacterBitmaps(json)
+				assert.Equal(t, expected, actual)
+
+				quotes := make([]uint64, len(expected.quotes))
+				for i := range quotes {
+					quotes[i] = r.Uint64()
+				}
+				var expectedMask []uint64
+				withoutSIMD(func() { expectedMask = buildStringMaskBitmap(quotes) })
+				actualMask := buildStringMaskBitmap(quotes)
+				assert.Equalf(t, expectedMask, actualMask, "expected: %s, actual: %s", uint64SliceToBits(expectedMask), uint64SliceToBits(actualMask))
+			})
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package mison
+
+import (
+	"bytes"
+	"io"
+)
+
+const defaultStreamChunkSize = 1024 * 1024
+
+/*
+chunkedIndex builds the structural index of a document from io.Reader chunk by chunk.
+The escaping by backslashes and whether in a string are carried across chunks.
+*/
+type chunkedIndex struct {
+	r         io.Reader
+	chunkSize int
+	eof       bool
+	// buf has the bytes of the document from base to end
+	buf  []byte
+	base int
+	end  int
+	// the offset of the first byte of the last chunk
+	chunkStart int
+	// events has the structural characters and quotes of the last chunk
+	events []uint64
+	// carried states
+	escapedCarry uint64
+	inString     uint64
+	// for locating errors
+	line      int
+	lineStart int
+}
+
+func newChunkedIndex(r io.Reader, chunkSize int) *chunkedIndex {
+	if chunkSize <= 0 {
+		chunkSize = defaultStreamChunkSize
+	}
+	// chunks are aligned to words
+	chunkSize = (chunkSize + wordSize - 1) / wordSize * wordSize
+	return &chunkedIndex{r: r, chunkSize: chunkSize, line: 1}
+}
+
+/*
+discard discards the bytes before keep.
+*/
+func (ci *chunkedIndex) discard(keep int) {
+	n := keep - ci.base
+	if n <= 0 {
+		return
+	}
+	discarded := ci.buf[:n]
+	ci.line += bytes.Count(discarded, []byte{'\n'})
+	if i := bytes.LastIndexByte(discarded, '\n'); i >= 0 {
+		ci.lineStart = ci.base + i + 1
+	}
+	ci.buf = ci.buf[:copy(ci.buf, ci.buf[n:])]
+	ci.base = keep
+}
+
+/*
+next discards the bytes before keep, and reads and indexes the next chunk.
+It returns false when no bytes are read.
+*/
+func (ci *chunkedIndex) next(keep int) (bool, error) {
+	if ci.eof {
+		return false, nil
+	}
+	ci.discard(keep)
+
+	n := len(ci.buf)
+	if cap(ci.buf) < n+ci.chunkSize {
+		buf := make([]byte, n, 2*n+ci.chunkSize)
+		copy(buf, ci.buf)
+		ci.buf = buf
+	}
+	m, err := io.ReadFull(ci.r, ci.buf[n:n+ci.chunkSize])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		ci.eof = true
+	} else if err != nil {
+		return false, err
+	}
+	ci.buf = ci.buf[:n+m]
+	if m == 0 {
+		return false, nil
+	}
+
+	ci.chunkStart = ci.end
+	ci.end += m
+	ci.buildEvents(ci.buf[n:])
+	return true, nil
+}
+
+func (ci *chunkedIndex) buildEvents(chunk []byte) {
+	bitmaps := buildStructualCharacterBitmaps(chunk)
+	bitmapLen := len(bitmaps.quotes)
+	if cap(ci.events) < bitmapLen {
+		ci.events = make([]uint64, bitmapLen)
+	}
+	ci.events = ci.events[:bitmapLen]
+
+	for i := 0; i < bitmapLen; i++ {
+		escaped := escapedBits(bitmaps.backslashes[i], &ci.escapedCarry)
+		quotes := bitmaps.quotes[i] &^ escaped
+		prefix := prefixXor(quotes) ^ ci.inString
+		stringMask := prefix ^ quotes
+		ci.inString = uint64(int64(prefix) >> (wordSize - 1))
+
+		structuals := bitmaps.colons[i] | bitmaps.commas[i] | bitmaps.lBraces[i] | bitmaps.rBraces[i] | bitmaps.lBrackets[i] | bitmaps.rBrackets[i]
+		ci.events[i] = structuals&^stringMask | quotes
+	}
+}
+
+func (ci *chunkedIndex) byteAt(offset int) byte {
+	return ci.buf[offset-ci.base]
+}
+
+func (ci *chunkedIndex) syntaxError(offset int, err error, format string, args ...interface{}) *SyntaxError {
+	return newSyntaxError(offset, err, format, args...).locateAt(ci.buf, ci.base, ci.line, ci.lineStart)
+}
+
+/*
+relocate converts the offset of SyntaxError in the part of the document starting at start into the offset in the document.
+*/
+func (ci *chunkedIndex) relocate(err error, start int) error {
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		syntaxErr.Offset += start
+		syntaxErr.locateAt(ci.buf, ci.base, ci.line, ci.lineStart)
+	}
+	return err
+}
+
+type streamFrame struct {
+	start   int
+	isArray bool
+	// for object frame
+	table     queriedFieldTable
+	expectKey bool
+	// for array frame
+	array *queriedFieldEntry
+	// the current value
+	entry      *queriedFieldEntry
+	valueStart int
+	compound   bool
+	// the starting quote of the nearest field name on the path to the current value
+	key int
+	// the index of the current element of array frame
+	element int
+}
+
+/*
+StreamParserState is state of parsing a single JSON document from io.Reader.
+The structural index is built chunk by chunk, and only the bytes of the field name or the value being parsed are kept,
+so huge documents can be parsed with bounded memory.
+*/
+type StreamParserState struct {
+	p        *Parser
+	index    *chunkedIndex
+	stack    []streamFrame
+	word     int
+	events   uint64
+	inQuote  bool
+	keyStart int
+	keyEnd   int
+	closed   bool
+	finished bool
+	done     bool
+	// the object or array being kept as the raw value of an atomic queried field
+	rawEntry *queriedFieldEntry
+	rawStart int
+	rawDepth int
+	// for early termination
+	resolved []bool
+	pending  int
+}
+
+/*
+StartStreamParse returns a new StreamParserState for the document read from r.
+The document is read by chunkSize bytes (rounded up to a multiple of 64), or 1 MiB when chunkSize is not positive.
+*/
+func (p *Parser) StartStreamParse(r io.Reader, chunkSize int) *StreamParserState {
+	return &StreamParserState{
+		p:        p,
+		index:    newChunkedIndex(r, chunkSize),
+		stack:    make([]streamFrame, 0, p.level+1),
+		word:     -1,
+		keyStart: -1,
+		keyEnd:   -1,
+		resolved: make([]bool, len(p.slots)),
+		pending:  len(p.slots),
+	}
+}
+
+/*
+keep returns the offset of the first byte which is needed to parse the rest.
+*/
+func (ss *StreamParserState) keep() int {
+	keep := ss.index.end
+	if len(ss.stack) == 0 {
+		return keep
+	}
+	top := &ss.stack[len(ss.stack)-1]
+	if !top.isArray && top.expectKey && ss.keyStart >= 0 && ss.keyStart < keep {
+		keep = ss.keyStart
+	}
+	if top.entry != nil && !top.compound && top.valueStart < keep {
+		keep = top.valueStart
+	}
+	if ss.rawEntry != nil && ss.rawStart < keep {
+		keep = ss.rawStart
+	}
+	return keep
+}
+
+/*
+nextEvent returns the offset of the next structural character or quote, or -1 at the end of the document.
+*/
+func (ss *StreamParserState) nextEvent() (int, error) {
+	for ss.events == 0 {
+		ss.word++
+		if ss.word >= len(ss.index.events) {
+			ok, err := ss.index.next(ss.keep())
+			if err != nil || !ok {
+				return -1, err
+			}
+			ss.word = 0
+		}
+		ss.events = ss.index.events[ss.word]
+	}
+	bit := extractRightmost1(ss.events)
+	ss.events = removeRightmost1(ss.events)
+	return ss.index.chunkStart + bitPosition(ss.word, bit), nil
+}
+
+func (ss *StreamParserState) resolve(entry *queriedFieldEntry) {
+	slot, ok := ss.p.slots[entry]
+	if !ok || ss.resolved[slot] {
+		return
+	}
+	ss.resolved[slot] = true
+	ss.pending--
+	if ss.pending == 0 {
+		ss.finished = true
+	}
+}
+
+/*
+Next returns next key/value.
+Like ParserState.Next, the end of record is returned as soon as all queried fields are found.
+*/
+func (ss *StreamParserState) Next() (*KeyValue, error) {
+	if ss.done {
+		return nil, ErrAlreadyFinished
+	}
+
+	for !ss.finished {
+		offset, err := ss.nextEvent()
+		if err != nil {
+			return nil, err
+		}
+		if offset < 0 {
+			if len(ss.stack) > 0 {
+				return nil, ss.index.syntaxError(ss.stack[len(ss.stack)-1].start, ErrUnclosed, "unclosed left brace or bracket")
+			}
+			break
+		}
+
+		kv, err := ss.handleEvent(offset)
+		if err != nil || kv != nil {
+			return kv, err
+		}
+	}
+
+	ss.done = true
+	return &KeyValue{FieldID: -1, Type: JSONEndOfRecord, Value: nil, RawValue: ""}, nil
+}
+
+func (ss *StreamParserState) handleEvent(offset int) (*KeyValue, error) {
+	c := ss.index.byteAt(offset)
+	if c == '"' {
+		ss.inQuote = !ss.inQuote
+		if len(ss.stack) == 0 {
+			return nil, nil
+		}
+		top := &ss.stack[len(ss.stack)-1]
+		if !top.isArray && top.expectKey {
+			if ss.inQuote {
+				ss.keyStart = offset
+				ss.keyEnd = -1
+			} else {
+				ss.keyEnd = offset
+			}
+		}
+		return nil, nil
+	}
+
+	if (c == '{' || c == '[' || c == '}' || c == ']') && ss.afterKey() {
+		return nil, ss.index.syntaxError(offset, ErrInvalidValue, "colon after field name is not found")
+	}
+
+	switch c {
+	case '{', '[':
+		if !ss.closed {
+			ss.pushFrame(offset, c == '[')
+		}
+		return nil, nil
+	case '}', ']':
+		return ss.popFrame(offset, c == ']')
+	}
+
+	if len(ss.stack) == 0 {
+		return nil, nil
+	}
+	top := &ss.stack[len(ss.stack)-1]
+	if c == ':' {
+		if top.isArray || !top.expectKey || ss.keyStart < 0 || ss.keyEnd < 0 {
+			return nil, ss.index.syntaxError(offset, ErrFieldNameNotFound, "field name for colon is not found")
+		}
+		name, err := decodeFieldName(ss.index.buf[ss.keyStart+1-ss.index.base:ss.keyEnd-ss.index.base], ss.p.literalOptions)
+		if err != nil {
+			return nil, ss.index.relocate(stringSyntaxError(nil, 0, err), ss.keyStart+1)
+		}
+		top.entry = nil
+		if top.table != nil {
+			top.entry = top.table[string(name)]
+		}
+		top.expectKey = false
+		top.valueStart = offset
+		top.compound = false
+		top.key = ss.keyStart
+		ss.keyStart = -1
+		return nil, nil
+	}
+
+	// comma
+	kv, err := ss.finishValue(top, offset)
+	if err != nil {
+		return nil, err
+	}
+	if top.isArray {
+		top.entry = nil
+		if top.array != nil {
+			top.entry = top.array.element
+		}
+		top.valueStart = offset
+		top.compound = false
+		top.element++
+	} else {
+		top.entry = nil
+		top.expectKey = true
+		ss.keyStart = -1
+	}
+	return kv, nil
+}
+
+/*
+afterKey reports whether a field name is closed and its colon is not found yet.
+*/
+func (ss *StreamParserState) afterKey() bool {
+	if len(ss.stack) == 0 {
+		return false
+	}
+	top := &ss.stack[len(ss.stack)-1]
+	return !top.isArray && top.expectKey && ss.keyStart >= 0 && ss.keyEnd >= 0
+}
+
+func (ss *StreamParserState) pushFrame(offset int, isArray bool) {
+	// keys of other frames must not be taken as the key of the new frame
+	ss.keyStart, ss.keyEnd = -1, -1
+	var entry *queriedFieldEntry
+	key := -1
+	if len(ss.stack) == 0 {
+		entry = &queriedFieldEntry{id: queriedFieldObject, children: ss.p.queriedFieldTable}
+	} else {
+		parent := &ss.stack[len(ss.stack)-1]
+		parent.compound = true
+		entry = parent.entry
+		key = parent.key
+		if entry != nil && entry.isAtomic() {
+			ss.rawEntry = entry
+			ss.rawStart = offset
+			ss.rawDepth = len(ss.stack)
+		}
+	}
+
+	frame := streamFrame{start: offset, isArray: isArray, valueStart: offset, key: key}
+	if isArray {
+		if entry != nil && entry.isArray() {
+			frame.array = entry
+			frame.entry = entry.element
+		}
+	} else {
+		frame.expectKey = true
+		if entry != nil && entry.isObject() {
+			frame.table = entry.children
+		}
+	}
+	ss.stack = append(ss.stack, frame)
+}
+
+func (ss *StreamParserState) popFrame(offset int, isArray bool) (*KeyValue, error) {
+	if len(ss.stack) == 0 {
+		if isArray {
+			return nil, ss.index.syntaxError(offset, ErrUnexpectedClosing, "unexpected right bracket")
+		}
+		return nil, ss.index.syntaxError(offset, ErrUnexpectedClosing, "unexpected right curry blace")
+	}
+	top := &ss.stack[len(ss.stack)-1]
+	if top.isArray != isArray {
+		return nil, ss.index.syntaxError(offset, ErrUnexpectedClosing, "mismatched closing character")
+	}
+
+	kv, err := ss.finishValue(top, offset)
+	if err != nil {
+		return nil, err
+	}
+	ss.keyStart, ss.keyEnd = -1, -1
+	if top.array != nil {
+		ss.resolve(top.array)
+	}
+	ss.stack = ss.stack[:len(ss.stack)-1]
+	if len(ss.stack) == 0 {
+		// the rest is scanned only for unexpected closing characters
+		ss.closed = true
+	}
+	if ss.rawEntry != nil && len(ss.stack) == ss.rawDepth {
+		kv = ss.finishRawValue(offset, isArray)
+	}
+	return kv, nil
+}
+
+/*
+finishRawValue returns the object or array which ends at end as the raw value of the atomic queried field.
+*/
+func (ss *StreamParserState) finishRawValue(end int, isArray bool) *KeyValue {
+	entry := ss.rawEntry
+	ss.rawEntry = nil
+	l := literal{start: 0, end: end - ss.rawStart + 1, t: JSONObject}
+	if isArray {
+		l.t = JSONArray
+	}
+	raw := ss.index.buf[ss.rawStart-ss.index.base : end-ss.index.base+1]
+	v, _ := l.decode(raw, ss.p.literalOptions)
+	ss.resolve(entry)
+	return ss.newKeyValue(entry, l, v, raw, ss.rawStart)
+}
+
+/*
+newKeyValue returns the KeyValue of the literal l in json which starts at base in the document.
+The path to the value is taken from the stack.
+*/
+func (ss *StreamParserState) newKeyValue(entry *queriedFieldEntry, l literal, v interface{}, json []byte, base int) *KeyValue {
+	var indices []int
+	for i := range ss.stack {
+		if frame := &ss.stack[i]; frame.isArray {
+			indices = append(indices, frame.element)
+		}
+	}
+	return &KeyValue{
+		FieldID:   entry.id,
+		Type:      l.t,
+		Value:     v,
+		RawValue:  string(json[l.start:l.end]),
+		Start:     base + l.start,
+		End:       base + l.end,
+		KeyOffset: ss.stack[len(ss.stack)-1].key,
+		Indices:   indices,
+	}
+}
+
+/*
+finishValue parses the current value of the frame which ends at end.
+*/
+func (ss *StreamParserState) finishValue(frame *streamFrame, end int) (*KeyValue, error) {
+	if frame.entry == nil || frame.compound || (!frame.isArray && frame.expectKey) {
+		return nil, nil
+	}
+
+	json := ss.index.buf[frame.valueStart-ss.index.base : end-ss.index.base+1]
+	if frame.isArray && skipBlanks(json, 1) == len(json)-1 {
+		// empty array
+		if frame.valueStart == frame.start && ss.index.byteAt(end) == ']' {
+			return nil, nil
+		}
+		return nil, ss.index.syntaxError(frame.valueStart+1, ErrValueNotFound, "array element is not found")
+	}
+	if !frame.entry.isAtomic() {
+		return nil, nil
+	}
+
+	l, err := findLiteral(json, 0)
+	if err != nil {
+		return nil, ss.index.relocate(err, frame.valueStart)
+	}
+	v, err := l.decode(json, ss.p.literalOptions)
+	if err != nil {
+		return nil, ss.index.relocate(err, frame.valueStart)
+	}
+	ss.resolve(frame.entry)
+	return ss.newKeyValue(frame.entry, l, v, json, frame.valueStart), nil
+}
@@ -0,0 +1,307 @@
+package mison
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapedBits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 100; trial++ {
+		json := make([]byte, 64*4)
+		for i := range json {
+			json[i] = `\\\"a`[r.Intn(5)]
+		}
+
+		var carry uint64
+		bitmaps := buildStructualCharacterBitmaps(json)
+		for i, backslashes := range bitmaps.backslashes {
+			escaped := escapedBits(backslashes, &carry)
+			for j := 0; j < 64; j++ {
+				run := 0
+				for k := i*64 + j - 1; k >= 0 && json[k] == '\\'; k-- {
+					run++
+				}
+				assert.Equal(t, run%2 == 1, escaped&(1<<uint(j)) != 0, "%d of %q", i*64+j, json)
+			}
+		}
+	}
+}
+
+func TestChunkedIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 64, 100, 300} {
+		for trial := 0; trial < 20; trial++ {
+			json := randomJSONLike(r, n)
+			t.Run(fmt.Sprintf("n=%d,trial=%d", n, trial), func(t *testing.T) {
+				bitmaps := buildStructualCharacterBitmaps(json)
+				quotes, err := buildStructualQuoteBitmap(bitmaps)
+				if err != nil {
+					t.Skip(err)
+				}
+				stringMask := buildStringMaskBitmap(quotes)
+				expected := make([]int, 0)
+				for i := range json {
+					w, bit := i/64, uint64(1)<<uint(i%64)
+					structual := (bitmaps.colons[w]|bitmaps.commas[w]|bitmaps.lBraces[w]|bitmaps.rBraces[w]|bitmaps.lBrackets[w]|bitmaps.rBrackets[w])&^stringMask[w] | quotes[w]
+					if structual&bit != 0 {
+						expected = append(expected, i)
+					}
+				}
+
+				ci := newChunkedIndex(strings.NewReader(string(json)), 64)
+				actual := make([]int, 0)
+				for {
+					ok, err := ci.next(ci.end)
+					if !assert.NoError(t, err) || !ok {
+						break
+					}
+					for w, events := range ci.events {
+						for ; events != 0; events = removeRightmost1(events) {
+							actual = append(actual, ci.chunkStart+bitPosition(w, extractRightmost1(events)))
+						}
+					}
+				}
+				assert.Equal(t, expected, actual)
+			})
+		}
+	}
+}
+
+func collectStreamKeyValues(ss *StreamParserState) ([]*KeyValue, error) {
+	kvs := make([]*KeyValue, 0)
+	for {
+		kv, err := ss.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.IsEndOfRecord() {
+			return kvs, nil
+		}
+		kvs = append(kvs, kv)
+	}
+}
+
+func TestStreamParserState(t *testing.T) {
+	cases := []struct {
+		json          string
+		queriedFields []string
+		expected      []*KeyValue
+	}{
+		{
+			json:          `{"b":2,"c":-3,"a":1}`,
+			queriedFields: []string{"a", "c"},
+			expected:      []*KeyValue{{FieldID: 1, Value: -3.0, RawValue: "-3", Type: JSONNumber}, {FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}},
+		},
+		{
+			json:          `{"a":"foo","b":"bar\"\\\n\\n","c\"d":{"e\\":"x"}}`,
+			queriedFields: []string{"a", "b", `c"d.e\\`},
+			expected:      []*KeyValue{{FieldID: 0, Value: "foo", RawValue: `"foo"`, Type: JSONString}, {FieldID: 1, Value: "bar\"\\\n\\n", RawValue: `"bar\"\\\n\\n"`, Type: JSONString}, {FieldID: 2, Value: "x", RawValue: `"x"`, Type: JSONString}},
+		},
+		{
+			json:          `{"a":{"b":0},"c":{"a":1,"b":[1,{"c":2}]}}`,
+			queriedFields: []string{"a", "c.b[].c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: json.RawMessage(`{"b":0}`), RawValue: `{"b":0}`, Type: JSONObject}, {FieldID: 1, Value: 2.0, RawValue: "2", Type: JSONNumber}},
+		},
+		{
+			json:          `{"a":[[1,2],[],[[3]],4,[5]],"b":{"c":[ ]}}`,
+			queriedFields: []string{"a[][]", "b.c[]"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 0, Value: json.RawMessage(`[3]`), RawValue: "[3]", Type: JSONArray}, {FieldID: 0, Value: 5.0, RawValue: "5", Type: JSONNumber}},
+		},
+		{
+			json:          `{"a":1,"a":2,"c":3,"a":4}`,
+			queriedFields: []string{"a", "c"},
+			expected:      []*KeyValue{{FieldID: 0, Value: 1.0, RawValue: "1", Type: JSONNumber}, {FieldID: 0, Value: 2.0, RawValue: "2", Type: JSONNumber}, {FieldID: 1, Value: 3.0, RawValue: "3", Type: JSONNumber}},
+		},
+		{
+			json:          `{"a":{"x":"}]","y":[1,{"z":null}]} ,"b":[ ],"c":[{"a":[]}]}`,
+			queriedFields: []string{"a", "b", "c[].a"},
+			expected: []*KeyValue{
+				{FieldID: 0, Value: json.RawMessage(`{"x":"}]","y":[1,{"z":null}]}`), RawValue: `{"x":"}]","y":[1,{"z":null}]}`, Type: JSONObject},
+				{FieldID: 1, Value: json.RawMessage(`[ ]`), RawValue: "[ ]", Type: JSONArray},
+				{FieldID: 2, Value: json.RawMessage(`[]`), RawValue: "[]", Type: JSONArray},
+			},
+		},
+		{
+			json:          `[{"a":1}]`,
+			queriedFields: []string{"a"},
+			expected:      []*KeyValue{},
+		},
+		{
+			json:          ``,
+			queriedFields: []string{"a"},
+			expected:      []*KeyValue{},
+		},
+	}
+
+	for i, tt := range cases {
+		for _, pad := range []int{0, 30, 61, 62, 63, 64} {
+			json := strings.Repeat(" ", pad) + tt.json
+			t.Run(fmt.Sprintf("case%d (pad=%d): %s", i, pad, tt.json), func(t *testing.T) {
+				p, err := NewParser(tt.queriedFields)
+				if !assert.NoError(t, err) {
+					return
+				}
+				actual, err := collectStreamKeyValues(p.StartStreamParse(strings.NewReader(json), 64))
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expected, clearPositions(actual))
+				}
+			})
+		}
+	}
+}
+
+func TestStreamParserStateMatchesParserState(t *testing.T) {
+	p, err := NewParser([]string{"id", "items[].name", "items[].tags[]", "items[].attrs", "payload.size"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	json := benchmarkRecord()
+	ps, err := p.StartParse(json)
+	if !assert.NoError(t, err) {
+		return
+	}
+	expected := make([]*KeyValue, 0)
+	for {
+		kv, err := ps.Next()
+		if !assert.NoError(t, err) || kv.IsEndOfRecord() {
+			break
+		}
+		expected = append(expected, kv)
+	}
+
+	for _, chunkSize := range []int{1, 64, 100, 4096, 0} {
+		t.Run(fmt.Sprintf("chunkSize=%d", chunkSize), func(t *testing.T) {
+			actual, err := collectStreamKeyValues(p.StartStreamParse(strings.NewReader(string(json)), chunkSize))
+			if assert.NoError(t, err) {
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestStreamParserStateErrors(t *testing.T) {
+	cases := []struct {
+		json     string
+		sentinel error
+		offset   int
+		line     int
+		column   int
+	}{
+		{json: `{"a":1}}`, sentinel: ErrUnexpectedClosing, offset: 7, line: 1, column: 8},
+		{json: "{\"b\":[1,\n2}", sentinel: ErrUnexpectedClosing, offset: 10, line: 2, column: 2},
+		{json: `{"a":{"b":1}`, sentinel: ErrUnclosed, offset: 0, line: 1, column: 1},
+		{json: `{"a":}`, sentinel: ErrInvalidValue, offset: 5, line: 1, column: 6},
+		{json: "{\n" + strings.Repeat(" ", 100) + "\n\"a\":tru}", sentinel: ErrInvalidValue, offset: 107, line: 3, column: 5},
+		{json: `{"a":"\x"}`, sentinel: ErrInvalidString, offset: 6, line: 1, column: 7},
+		{json: `{"\q":1}`, sentinel: ErrInvalidString, offset: 2, line: 1, column: 3},
+		{json: `{1:1}`, sentinel: ErrFieldNameNotFound, offset: 2, line: 1, column: 3},
+		{json: `{"b":[1,,2]}`, sentinel: ErrValueNotFound, offset: 8, line: 1, column: 9},
+	}
+
+	for i, tt := range cases {
+		t.Run(fmt.Sprintf("case%d: %s", i, tt.json), func(t *testing.T) {
+			p, err := NewParser([]string{"a", "b[]"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = collectStreamKeyValues(p.StartStreamParse(strings.NewReader(tt.json), 64))
+
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr), "%v", err) {
+				assert.True(t, errors.Is(err, tt.sentinel), "%v", err)
+				assert.Equal(t, tt.offset, syntaxErr.Offset)
+				assert.Equal(t, tt.line, syntaxErr.Line)
+				assert.Equal(t, tt.column, syntaxErr.Column)
+			}
+		})
+	}
+}
+
+func TestStreamParserStateKeyWithoutColon(t *testing.T) {
+	inputs := []string{
+		`{"x"{"y"}:1}`,
+		`{"x"[` + strings.Repeat(" ", 200) + `]:1}`,
+		`{"x"}`,
+		`{"x"]`,
+	}
+
+	for i, json := range inputs {
+		for _, chunkSize := range []int{1, 64, 100, 0} {
+			t.Run(fmt.Sprintf("input%d,chunkSize=%d", i, chunkSize), func(t *testing.T) {
+				p, err := NewParser([]string{"x", "y"})
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = collectStreamKeyValues(p.StartStreamParse(strings.NewReader(json), chunkSize))
+
+				var syntaxErr *SyntaxError
+				if assert.True(t, errors.As(err, &syntaxErr), "%v", err) {
+					assert.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+					assert.Equal(t, 4, syntaxErr.Offset)
+				}
+			})
+		}
+	}
+}
+
+/*
+hugeDocumentReader generates {"a":[{"x":"...","b":0},...,{"x":"...","b":n-1}],"c":true} without holding it.
+*/
+type hugeDocumentReader struct {
+	n       int
+	i       int
+	pending []byte
+}
+
+func (r *hugeDocumentReader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		switch {
+		case r.i < 0:
+			return 0, io.EOF
+		case r.i == 0:
+			r.pending = []byte(`{"a":[`)
+		case r.i <= r.n:
+			r.pending = []byte(fmt.Sprintf(`{"x":"%s","b":%d},`, strings.Repeat("-", 1000), r.i-1))
+			if r.i == r.n {
+				r.pending = r.pending[:len(r.pending)-1]
+			}
+		default:
+			r.pending = []byte(`],"c":true}`)
+			r.i = -2
+		}
+		r.i++
+	}
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func TestStreamParserStateBoundedMemory(t *testing.T) {
+	p, err := NewParser([]string{"a[].b", "c"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	n := 10000
+	ss := p.StartStreamParse(&hugeDocumentReader{n: n}, 4096)
+	for i := 0; i < n; i++ {
+		kv, err := ss.Next()
+		if !assert.NoError(t, err) || !assert.Equal(t, float64(i), kv.Value) {
+			return
+		}
+	}
+	kv, err := ss.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, kv.Value)
+	}
+	assert.True(t, cap(ss.index.buf) <= 3*4096, "buffer grows to %d", cap(ss.index.buf))
+}